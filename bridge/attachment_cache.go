@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// attachmentCacheLimit is the number of messages to remember prepared attachments for
+const attachmentCacheLimit = 100
+
+// preparedAttachments are a message's attachments, after fetching their
+// descriptions and mirroring them
+type preparedAttachments struct {
+	done         chan struct{} // closed once prepared
+	attachments  []*discordgo.MessageAttachment
+	descriptions map[string]string
+}
+
+// attachmentCache prepares the attachments of recent Discord messages
+// once, for all of the networks relaying them.
+//
+// It is safe to use from multiple goroutines.
+type attachmentCache struct {
+	mu       sync.Mutex
+	messages map[string]*preparedAttachments // by Discord message ID
+	order    []string                        // Discord message IDs, oldest first
+}
+
+func newAttachmentCache() *attachmentCache {
+	return &attachmentCache{messages: make(map[string]*preparedAttachments)}
+}
+
+// Prepare returns the prepared attachments of a message, calling prepare
+// if they haven't been prepared yet, or waiting if they are being prepared
+func (c *attachmentCache) Prepare(messageID string, prepare func() ([]*discordgo.MessageAttachment, map[string]string)) ([]*discordgo.MessageAttachment, map[string]string) {
+	c.mu.Lock()
+	p, ok := c.messages[messageID]
+	if !ok {
+		p = &preparedAttachments{done: make(chan struct{})}
+		c.messages[messageID] = p
+		c.order = append(c.order, messageID)
+		for len(c.order) > attachmentCacheLimit {
+			delete(c.messages, c.order[0])
+			c.order = c.order[1:]
+		}
+	}
+	c.mu.Unlock()
+
+	if ok {
+		<-p.done
+	} else {
+		p.attachments, p.descriptions = prepare()
+		close(p.done)
+	}
+	return p.attachments, p.descriptions
+}
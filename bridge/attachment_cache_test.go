@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAttachmentCachePreparesOnce(t *testing.T) {
+	c := newAttachmentCache()
+
+	var mu sync.Mutex
+	calls := 0
+	prepare := func() ([]*discordgo.MessageAttachment, map[string]string) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return []*discordgo.MessageAttachment{{ID: "a"}}, map[string]string{"a": "a cat"}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attachments, descriptions := c.Prepare("1", prepare)
+			assert.Equal(t, "a", attachments[0].ID)
+			assert.Equal(t, "a cat", descriptions["a"])
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestAttachmentCacheLimit(t *testing.T) {
+	c := newAttachmentCache()
+	for i := 0; i <= attachmentCacheLimit; i++ {
+		c.Prepare(strconv.Itoa(i), func() ([]*discordgo.MessageAttachment, map[string]string) {
+			return nil, nil
+		})
+	}
+
+	assert.Len(t, c.messages, attachmentCacheLimit)
+	assert.Len(t, c.order, attachmentCacheLimit)
+}
@@ -3,14 +3,19 @@ package bridge
 import (
 	"crypto/tls"
 	"fmt"
+	"net/http"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
 	"github.com/qaisjp/go-discord-irc/irc/varys"
+	"github.com/qaisjp/go-discord-irc/mirror"
+	"github.com/qaisjp/go-discord-irc/store"
 	irc "github.com/qaisjp/go-ircevent"
 	log "github.com/sirupsen/logrus"
 )
@@ -20,6 +25,10 @@ type Config struct {
 	AvatarURL                string
 	DiscordBotToken, GuildID string
 
+	// ExtraGuildIDs are more Discord guilds to bridge channels from,
+	// as well as GuildID
+	ExtraGuildIDs []string
+
 	// Map from Discord to IRC
 	ChannelMappings map[string]string
 
@@ -65,6 +74,94 @@ type Config struct {
 	// ShowJoinQuit determines whether or not to show JOIN, QUIT, KICK messages on Discord
 	ShowJoinQuit bool
 
+	// ChannelSettings override the settings above for the mappings of an
+	// IRC channel, by lowercase channel name
+	ChannelSettings map[string]*MappingSettings
+
+	// AttachmentLimits limits how many attachment lines are relayed to IRC
+	// for each Discord message, by IRC channel. Channels not listed are unlimited.
+	AttachmentLimits map[string]int
+
+	// AttachmentMirrorDir is where Discord attachments are mirrored to,
+	// so that links on IRC don't expire. Blank disables the mirror.
+	AttachmentMirrorDir string
+	// AttachmentMirrorListen is the address the mirror's HTTP server listens on
+	AttachmentMirrorListen string
+	// AttachmentMirrorURL is the public URL of the mirror's HTTP server
+	AttachmentMirrorURL string
+	// AttachmentMirrorMaxAge is how long mirrored attachments are kept for
+	AttachmentMirrorMaxAge time.Duration
+	// AttachmentMirrorMaxSize is the total size in bytes of mirrored attachments
+	AttachmentMirrorMaxSize int64
+	// AttachmentMirrorMaxFile is the size in bytes of the largest attachment mirrored
+	AttachmentMirrorMaxFile int64
+
+	// StateFile is where settings changed at runtime are saved, like
+	// mappings added from Discord. Blank means they aren't saved.
+	StateFile string
+
+	// AdminRoles are the Discord roles that can manage the bridge from
+	// Discord, in addition to people with the Manage Channels permission
+	AdminRoles []string
+
+	// IRCCommandPrefix starts commands sent to the listener in IRC channels, like "!discord who"
+	IRCCommandPrefix string
+
+	// VoiceChannels maps Discord voice channel IDs to the IRC channels
+	// that joins, leaves and moves in them are announced in
+	VoiceChannels map[string]string
+
+	// TopicSync are the IRC channels whose topics are kept in sync with
+	// their Discord channels. Discord topics are only set on IRC if the
+	// listener is an operator.
+	TopicSync []string
+	// TopicPrefix is added to topics set on Discord from IRC
+	TopicPrefix string
+
+	// UserLists maps IRC channels to where a list of their users is kept
+	// on Discord, either a Discord channel ID, or "pin" to pin it in the
+	// bridged channel
+	UserLists map[string]string
+
+	// IRCPrefixChannels are the IRC channels where prefix modes are shown
+	// in front of IRC users' names on Discord, like "@alice"
+	IRCPrefixChannels []string
+
+	// RoleModes maps Discord role IDs to the channel modes their holders'
+	// puppets ask for when they join, like "o" or "v"
+	RoleModes map[string]string
+	// RoleModesVia is how puppets ask for modes: "chanserv" or "mode"
+	RoleModesVia string
+
+	// ModerationBridging bans the puppets of people banned on Discord, and
+	// quiets the puppets of people timed out, if the listener is an operator
+	ModerationBridging bool
+	// IRCQuietMode is the channel mode used to quiet puppets, like "+q"
+	IRCQuietMode string
+	// ModLogChannel is a Discord channel ID that moderation of puppets is
+	// logged to, like them being kicked or banned on IRC. Blank disables it.
+	ModLogChannel string
+
+	// PMChannel is a Discord channel ID that private messages from IRC are
+	// sent to instead of DMs, in a private thread for each IRC user
+	PMChannel string
+
+	// ReactionWindow is how long reactions to a Discord message are
+	// collected for, before they are summarised on IRC in one line
+	ReactionWindow time.Duration
+
+	// ShowDeletions determines whether or not deleting a relayed Discord message is shown on IRC
+	ShowDeletions bool
+
+	// DeletionNotice is sent to IRC when a relayed message is deleted, unless
+	// the server supports IRCv3 message redaction. ${USERNAME} is replaced
+	// with the nick the message was sent from.
+	DeletionNotice string
+
+	// IRCReplies turns IRC messages starting with "nick: " into replies to
+	// that nick's latest message on Discord
+	IRCReplies bool
+
 	// Maximum Nicklength for irc server
 	MaxNickLength int
 
@@ -72,6 +169,17 @@ type Config struct {
 	DebugPresence bool
 }
 
+// guildIDs returns all of the bridged guilds, the main guild first
+func (c *Config) guildIDs() []string {
+	ids := []string{c.GuildID}
+	for _, id := range c.ExtraGuildIDs {
+		if id != "" && id != c.GuildID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
 // A Bridge represents a bridging between an IRC server and channels in a Discord server
 type Bridge struct {
 	Config *Config
@@ -81,20 +189,54 @@ type Bridge struct {
 	ircManager  *IRCManager
 
 	mappings       []Mapping
-	ircChannelKeys map[string]string // From "#test" to "password"
+	ircChannelKeys map[string]string // From lowercase "#test" to "password"
+
+	// channel_mappings from the config, before changes made from Discord
+	configMappings map[string]string
+
+	// Settings changed at runtime, nil if they aren't saved
+	store *store.Store
+
+	// Held while mappings are being changed from Discord
+	mappingChangesMu sync.Mutex
+
+	// Lists of who is in IRC channels, kept on Discord
+	userLists *userLists
 
 	done chan bool
 
 	discordMessagesChan      chan IRCMessage
 	discordMessageEventsChan chan *DiscordMessage
+	discordDeletionsChan     chan []string // message ids
+	discordReactionsChan     chan DiscordReaction
 	updateUserChan           chan DiscordUser
 	removeUserChan           chan string // user id
+	updateChannelsChan       chan struct{}
+	loopFuncs                chan func() // run on the loop, see inLoop
+
+	// Custom emoji by lowercase name, by guild ID
+	emojiMu sync.Mutex
+	emoji   map[string]map[string]*discordgo.Emoji
+
+	// Messages recently sent to Discord by IRC users
+	webhookHistory *webhookHistory
+
+	// Mirror of Discord attachments, nil if disabled
+	mirror       *mirror.Mirror
+	mirrorServer *http.Server // nil for other networks, which share the mirror
 
-	emoji map[string]*discordgo.Emoji
+	// The bridge that owns the Discord session, nil if this is it
+	primary *Bridge
+	// Other IRC networks sharing this bridge's Discord session
+	networks []*Bridge
 }
 
 // Close the Bridge
 func (b *Bridge) Close() {
+	for _, network := range b.networks {
+		network.Close()
+	}
+
 	b.done <- true
 	<-b.done
 }
@@ -118,36 +260,23 @@ func (b *Bridge) load(opts *Config) error {
 // SetChannelMappings allows you to set (or update) the
 // hashmap containing irc to discord mappings.
 //
+// Changes made from Discord with AddMapping and RemoveMapping are
+// applied on top of these mappings.
+//
 // Calling this function whilst the bot is running will
 // add or remove IRC bots accordingly.
 func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
-	var mappings []Mapping
-	ircChannelKeys := make(map[string]string, len(mappings))
-	for irc, discord := range inMappings {
-		ircParts := strings.Split(irc, " ")
-		ircChannel := ircParts[0]
-		if parts := len(ircParts); parts != 1 && parts > 2 {
-			log.Errorf("IRC channel irc %+v (to discord %+v) is invalid. Expected 0 or 1 spaces in the string. Ignoring.", irc, discord)
-			continue
-		} else if parts == 2 {
-			ircChannelKeys[ircChannel] = ircParts[1]
-		}
+	b.mappingChangesMu.Lock()
+	defer b.mappingChangesMu.Unlock()
 
-		mappings = append(mappings, Mapping{
-			DiscordChannel: discord,
-			IRCChannel:     ircChannel,
-		})
-	}
+	b.configMappings = inMappings
+	return b.setChannelMappings(mergeMappings(inMappings, b.mappingChanges()))
+}
 
-	// Check for duplicate channels
-	for i, mapping := range mappings {
-		for j, check := range mappings {
-			if (mapping.DiscordChannel == check.DiscordChannel) || (mapping.IRCChannel == check.IRCChannel) {
-				if i != j {
-					return errors.New("channel_mappings contains duplicate entries")
-				}
-			}
-		}
+func (b *Bridge) setChannelMappings(inMappings map[string]string) error {
+	mappings, ircChannelKeys, err := parseMappings(inMappings)
+	if err != nil {
+		return err
 	}
 
 	oldMappings := b.mappings
@@ -156,25 +285,8 @@ func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
 
 	// If doing some changes mid-bot
 	if oldMappings != nil {
-		var newMappings []Mapping
 		var removedMappings []Mapping
 
-		// Find positive difference
-		// These are the items in the new mappings list, but not the oldMappings
-		for _, mapping := range mappings {
-			found := false
-			for _, curr := range oldMappings {
-				if curr == mapping {
-					found = true
-					break
-				}
-			}
-
-			if !found {
-				newMappings = append(newMappings, mapping)
-			}
-		}
-
 		// Find negative difference
 		// These are the items in the oldMappings, but not the new one
 		for _, mapping := range oldMappings {
@@ -195,12 +307,13 @@ func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
 		rmChannels := []string{}
 		for _, mapping := range removedMappings {
 			// Looking for the irc channel to remove
-			// inside our list of newly added channels.
+			// inside our list of new mappings.
 			//
-			// This will prevent swaps from joinquitting the bots.
+			// This will prevent swaps from joinquitting the bots,
+			// and keeps them in channels that are still in other mappings.
 			found := false
-			for _, curr := range newMappings {
-				if curr.IRCChannel == mapping.IRCChannel {
+			for _, curr := range mappings {
+				if strings.EqualFold(curr.IRCChannel, mapping.IRCChannel) {
 					found = true
 				}
 			}
@@ -217,41 +330,147 @@ func (b *Bridge) SetChannelMappings(inMappings map[string]string) error {
 			panic(err.Error())
 		}
 
-		// The bots needs to join the new mappings
+		// The bots needs to join the new mappings. Puppets also leave
+		// channels that Discord can't send to, or they can't see, any more.
 		b.ircListener.JoinChannels()
-		for _, conn := range b.ircManager.ircConnections {
-			conn.JoinChannels()
-		}
+		b.ircManager.updateChannels()
 	}
 
 	return nil
 }
 
+// parseMappings turns channel_mappings into a list of mappings, and the
+// keys of IRC channels.
+//
+// Channels can be in several mappings: a value can list several Discord
+// channels separated by commas, and several IRC channels can have the same
+// Discord channel. Each Discord channel can be followed by a space and the
+// mapping's direction.
+func parseMappings(inMappings map[string]string) ([]Mapping, map[string]string, error) {
+	// Go through channels in order, so that the same config always gives
+	// the same mappings
+	ircs := make([]string, 0, len(inMappings))
+	for irc := range inMappings {
+		ircs = append(ircs, irc)
+	}
+	sort.Strings(ircs)
+
+	var mappings []Mapping
+	ircChannelKeys := make(map[string]string, len(inMappings))
+	for _, irc := range ircs {
+		discord := inMappings[irc]
+		ircParts := strings.Split(irc, " ")
+		ircChannel := ircParts[0]
+		if parts := len(ircParts); parts != 1 && parts > 2 {
+			log.Errorf("IRC channel irc %+v (to discord %+v) is invalid. Expected 0 or 1 spaces in the string. Ignoring.", irc, discord)
+			continue
+		} else if parts == 2 {
+			if key, ok := ircChannelKeys[strings.ToLower(ircChannel)]; ok && key != ircParts[1] {
+				return nil, nil, errors.Errorf("channel_mappings has different keys for %s", ircChannel)
+			}
+			ircChannelKeys[strings.ToLower(ircChannel)] = ircParts[1]
+		}
+
+		for _, entry := range discordChannels(discord) {
+			discordChannel, direction, err := parseMappingEntry(entry)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "invalid mapping of %s", ircChannel)
+			}
+
+			mapping := Mapping{
+				DiscordChannel: discordChannel,
+				IRCChannel:     ircChannel,
+				Direction:      direction,
+			}
+
+			// "#chan" and "#chan key" can both be mapped to the same channel
+			duplicate := false
+			for _, m := range mappings {
+				if m.DiscordChannel == mapping.DiscordChannel && strings.EqualFold(m.IRCChannel, mapping.IRCChannel) {
+					duplicate = true
+				}
+			}
+			if !duplicate {
+				mappings = append(mappings, mapping)
+			}
+		}
+	}
+
+	return mappings, ircChannelKeys, nil
+}
+
+// discordChannels splits a channel_mappings value into its Discord channels,
+// which can be followed by their direction
+func discordChannels(value string) []string {
+	var channels []string
+	for _, channel := range strings.Split(value, ",") {
+		if channel = strings.TrimSpace(channel); channel != "" {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
 // New Bridge
 func New(conf *Config) (*Bridge, error) {
+	return newBridge(conf, nil)
+}
+
+func newBridge(conf *Config, primary *Bridge) (*Bridge, error) {
 	dib := &Bridge{
 		Config: conf,
 		done:   make(chan bool),
 
 		discordMessagesChan:      make(chan IRCMessage),
 		discordMessageEventsChan: make(chan *DiscordMessage),
+		discordDeletionsChan:     make(chan []string),
+		discordReactionsChan:     make(chan DiscordReaction),
 		updateUserChan:           make(chan DiscordUser),
 		removeUserChan:           make(chan string),
+		updateChannelsChan:       make(chan struct{}),
+		loopFuncs:                make(chan func()),
 
-		emoji: make(map[string]*discordgo.Emoji),
+		emoji: make(map[string]map[string]*discordgo.Emoji),
+
+		webhookHistory: newWebhookHistory(),
+
+		primary: primary,
 	}
 
+	if conf.StateFile != "" {
+		var err error
+		if dib.store, err = store.Open(conf.StateFile); err != nil {
+			return nil, errors.Wrap(err, "could not open state file")
+		}
+	}
+
+	dib.userLists = newUserLists(dib)
+
 	if err := dib.load(conf); err != nil {
 		return nil, errors.Wrap(err, "configuration invalid")
 	}
 
 	var err error
 
-	dib.discord, err = newDiscord(dib, conf.DiscordBotToken, conf.GuildID)
-	if err != nil {
+	if primary != nil {
+		dib.discord = newNetworkDiscord(dib, primary.discord)
+		dib.mirror = primary.mirror
+	} else if dib.discord, err = newDiscord(dib, conf.DiscordBotToken, conf.guildIDs()); err != nil {
 		return nil, errors.Wrap(err, "Could not create discord bot")
 	}
 
+	if primary == nil && conf.AttachmentMirrorDir != "" {
+		dib.mirror, err = mirror.New(conf.AttachmentMirrorDir, conf.AttachmentMirrorURL, conf.AttachmentMirrorMaxAge, conf.AttachmentMirrorMaxSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "Could not create attachment mirror")
+		}
+		dib.mirror.MaxFileSize = conf.AttachmentMirrorMaxFile
+		dib.mirrorServer = &http.Server{
+			Addr:    conf.AttachmentMirrorListen,
+			Handler: dib.mirror,
+		}
+	}
+
 	dib.ircListener = newIRCListener(dib, conf.WebIRCPass)
 	if dib.ircManager, err = newIRCManager(dib); err != nil {
 		return nil, fmt.Errorf("failed to create ircManager: %w", err)
@@ -278,9 +497,12 @@ func (b *Bridge) SetDebugMode(debug bool) {
 func (b *Bridge) Open() (err error) {
 
 	// Open a websocket connection to Discord and begin listening.
-	err = b.discord.Open()
-	if err != nil {
-		return errors.Wrap(err, "can't open discord")
+	// Other networks use the primary bridge's connection.
+	if b.primary == nil {
+		err = b.discord.Open()
+		if err != nil {
+			return errors.Wrap(err, "can't open discord")
+		}
 	}
 
 	err = b.ircListener.Connect(b.Config.IRCServer)
@@ -291,6 +513,20 @@ func (b *Bridge) Open() (err error) {
 	// run listener loop
 	go b.ircListener.Loop()
 
+	if b.mirrorServer != nil {
+		go func() {
+			if err := b.mirrorServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Errorln("attachment mirror server stopped")
+			}
+		}()
+	}
+
+	for _, network := range b.networks {
+		if err = network.Open(); err != nil {
+			return errors.Wrapf(err, "can't open network %s", network.Config.Discriminator)
+		}
+	}
+
 	return
 }
 
@@ -322,9 +558,16 @@ func (b *Bridge) SetupIRCConnection(con *irc.Connection, hostname, ip string) {
 func (b *Bridge) GetJoinCommand(mappings []Mapping) string {
 	var channels, keyedChannels, keys []string
 
+	// IRC channels can be in several mappings, but only need joining once
+	joined := make(map[string]bool, len(mappings))
 	for _, mapping := range mappings {
 		channel := mapping.IRCChannel
-		key, keyed := b.ircChannelKeys[channel]
+		if joined[strings.ToLower(channel)] {
+			continue
+		}
+		joined[strings.ToLower(channel)] = true
+
+		key, keyed := b.ircChannelKeys[strings.ToLower(channel)]
 
 		if keyed {
 			keyedChannels = append(keyedChannels, channel)
@@ -351,6 +594,70 @@ func (b *Bridge) GetMappingByIRC(channel string) (Mapping, bool) {
 	return Mapping{}, false
 }
 
+// GetMappingsByIRC returns every Mapping of an IRC channel
+func (b *Bridge) GetMappingsByIRC(channel string) []Mapping {
+	var mappings []Mapping
+	for _, mapping := range b.mappings {
+		if strings.EqualFold(mapping.IRCChannel, channel) {
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings
+}
+
+// ircChannels returns the bridged IRC channels, each once
+func (b *Bridge) ircChannels() []string {
+	var channels []string
+	seen := make(map[string]bool, len(b.mappings))
+	for _, mapping := range b.mappings {
+		if !seen[strings.ToLower(mapping.IRCChannel)] {
+			seen[strings.ToLower(mapping.IRCChannel)] = true
+			channels = append(channels, mapping.IRCChannel)
+		}
+	}
+	return channels
+}
+
+// attachmentLimit returns how many attachments can be relayed
+// per message to an IRC channel, or 0 for no limit
+func (b *Bridge) attachmentLimit(ircChannel string) int {
+	for channel, limit := range b.Config.AttachmentLimits {
+		if strings.EqualFold(channel, ircChannel) {
+			return limit
+		}
+	}
+	return 0
+}
+
+// attachmentLimits groups the IRC channels that a Discord channel sends
+// to by how many attachments can be relayed to them per message
+func (b *Bridge) attachmentLimits(discordChannel string) map[int][]string {
+	limits := make(map[int][]string)
+	for _, mapping := range mappingsToIRC(b.GetMappingsByDiscord(discordChannel)) {
+		limit := b.attachmentLimit(mapping.IRCChannel)
+		limits[limit] = append(limits[limit], mapping.IRCChannel)
+	}
+	return limits
+}
+
+// maxAttachmentLimit returns the largest of some attachment limits,
+// which is 0 (no limit) if any of them are
+func maxAttachmentLimit(limits map[int][]string) int {
+	max := -1
+	for limit := range limits {
+		if limit == 0 {
+			return 0
+		}
+		if limit > max {
+			max = limit
+		}
+	}
+	if max < 0 {
+		return 0
+	}
+	return max
+}
+
 // GetMappingByDiscord returns a Mapping for a given Discord channel.
 // Returns nil if a Mapping does not exist.
 func (b *Bridge) GetMappingByDiscord(channel string) (Mapping, bool) {
@@ -362,121 +669,248 @@ func (b *Bridge) GetMappingByDiscord(channel string) (Mapping, bool) {
 	return Mapping{}, false
 }
 
+// GetMappingsByDiscord returns every Mapping of a Discord channel
+func (b *Bridge) GetMappingsByDiscord(channel string) []Mapping {
+	var mappings []Mapping
+	for _, mapping := range b.mappings {
+		if mapping.DiscordChannel == channel {
+			mappings = append(mappings, mapping)
+		}
+	}
+	return mappings
+}
+
 var emojiRegex = regexp.MustCompile("(:[a-zA-Z_-]+:)")
 
+// Allow user and role mentions, but not everyone or here mentions
+var webhookAllowedMentions = &discordgo.MessageAllowedMentions{
+	Parse: []discordgo.AllowedMentionType{
+		discordgo.AllowedMentionTypeRoles,
+		discordgo.AllowedMentionTypeUsers,
+	},
+}
+
+// discordContent prepares the text of a message from IRC to be sent to Discord
+func (b *Bridge) discordContent(guildID, content string) string {
+	// If the message has leading or trailing spaces, or if the message consists
+	// entirely of whitespace, we want Discord to display them as intended,
+	// rather than ignoring it. We surround the content with zero-width spaces
+	// to achieve this. For example, 3 space characters sent from IRC should
+	// render on Discord as 3 space characters too.
+	if content == "" || strings.TrimSpace(content) != content {
+		content = "\u200B" + content + "\u200B"
+	}
+
+	// Convert any emoji ye?
+	content = emojiRegex.ReplaceAllStringFunc(content, func(emoji string) string {
+		b.emojiMu.Lock()
+		e, ok := b.emoji[guildID][strings.ToLower(emoji[1:len(emoji)-1])]
+		b.emojiMu.Unlock()
+		if !ok {
+			return emoji
+		}
+
+		emoji = ":" + e.Name + ":" + e.ID
+		if e.Animated {
+			emoji = "a" + emoji
+		}
+
+		return "<" + emoji + ">"
+	})
+
+	return content
+}
+
+// CorrectMessage applies a sed-style substitution from an IRC user to their
+// latest matching message on Discord. Returns false if there was no message
+// to correct.
+func (b *Bridge) CorrectMessage(ircChannel, nick string, sub *substitution) bool {
+	corrected := false
+	for _, mapping := range mappingsToDiscord(b.GetMappingsByIRC(ircChannel)) {
+		if b.correctMessage(mapping, nick, sub) {
+			corrected = true
+		}
+	}
+	return corrected
+}
+
+// correctMessage corrects a message in the Discord channel of one mapping
+func (b *Bridge) correctMessage(mapping Mapping, nick string, sub *substitution) bool {
+	msg, ok := b.webhookHistory.Substitute(mapping.DiscordChannel, mapping.IRCChannel, nick, sub)
+	if !ok {
+		return false
+	}
+
+	content := b.ircListener.formatForDiscord(mapping.IRCChannel, msg.IRCText, msg.IsAction)
+	if msg.ReplyHeader != "" {
+		content = msg.ReplyHeader + "\n" + content
+	}
+	content = b.discordContent(b.discord.channelGuild(mapping.DiscordChannel), content)
+
+	go func() {
+		err := b.discord.transmitterFor(mapping.DiscordChannel).Edit(mapping.DiscordChannel, msg.ID, &discordgo.WebhookParams{
+			Content:         content,
+			AllowedMentions: webhookAllowedMentions,
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"error":       err,
+				"msg.channel": mapping.DiscordChannel,
+				"msg.id":      msg.ID,
+				"msg.content": content,
+			}).Errorln("could not edit message on discord")
+		}
+	}()
+
+	return true
+}
+
+// sendToDiscord sends a message from IRC to the Discord channel of a mapping
+func (b *Bridge) sendToDiscord(mapping Mapping, msg IRCMessage) {
+	var avatar string
+	username := msg.Prefix + msg.Username
+
+	// Say which IRC channel the message is from, if the Discord channel has several
+	tag := b.sourceTag(mapping)
+
+	// System messages have no username
+	if username != "" {
+		if tag != "" {
+			username = withSourceTag(username, tag)
+		}
+
+		avatar = b.discord.GetAvatar(b.discord.channelGuild(mapping.DiscordChannel), msg.Username)
+		if avatar == "" {
+			// If we don't have a Discord avatar, generate an adorable avatar
+			avatar = strings.ReplaceAll(b.settings(mapping.IRCChannel).AvatarURL, "${USERNAME}", msg.Username)
+		}
+
+		if len(username) == 1 {
+			// Append usernames with 1 character
+			// This is because Discord doesn't accept single character usernames
+			username += `.` // <- zero width space in here, ayylmao
+		}
+	}
+
+	// Messages addressed to someone who has spoken show as a reply
+	ircText, content := msg.IRCText, msg.Message
+	var replyHeader string
+	if msg.ReplyNick != "" {
+		if header, ok := b.replyHeader(mapping, msg.ReplyNick); ok {
+			_, ircText, _ = addressedTo(msg.IRCText)
+			replyHeader = header
+			content = header + "\n" + b.ircListener.formatForDiscord(mapping.IRCChannel, ircText, msg.IsAction)
+		}
+	}
+	content = b.discordContent(b.discord.channelGuild(mapping.DiscordChannel), content)
+
+	if username == "" {
+		if tag != "" {
+			content = "[" + tag + "] " + content
+		}
+
+		// System messages come straight from the bot
+		if _, err := b.discord.Session.ChannelMessageSend(mapping.DiscordChannel, content); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"msg.channel":  mapping.DiscordChannel,
+				"msg.username": username,
+				"msg.content":  content,
+			}).Errorln("could not transmit SYSTEM message to discord")
+		}
+	} else {
+		go func(msg IRCMessage) {
+			sent, err := b.discord.transmitterFor(mapping.DiscordChannel).Send(
+				mapping.DiscordChannel,
+				&discordgo.WebhookParams{
+					Username:        username,
+					AvatarURL:       avatar,
+					Content:         content,
+					AllowedMentions: webhookAllowedMentions,
+				},
+			)
+
+			if err != nil {
+				log.WithFields(log.Fields{
+					"error":        err,
+					"msg.channel":  mapping.DiscordChannel,
+					"msg.username": username,
+					"msg.avatar":   avatar,
+					"msg.content":  content,
+				}).Errorln("could not transmit message to discord")
+				return
+			}
+
+			// Remember it, so that it can be corrected later
+			if sent != nil && ircText != "" {
+				b.webhookHistory.Add(mapping.DiscordChannel, msg.Username, webhookMessage{
+					ID:          sent.ID,
+					IRCChannel:  msg.IRCChannel,
+					IRCText:     ircText,
+					IsAction:    msg.IsAction,
+					ReplyHeader: replyHeader,
+					IRCMsgID:    msg.IRCMsgID,
+				})
+			}
+		}(msg)
+	}
+}
+
+// inLoop runs f on the loop goroutine and waits for it, so that f can use
+// state owned by the loop, like the IRC connections. It must not be
+// called from the loop.
+func (b *Bridge) inLoop(f func()) {
+	done := make(chan struct{})
+	b.loopFuncs <- func() {
+		defer close(done)
+		f()
+	}
+	<-done
+}
+
 func (b *Bridge) loop() {
 	for {
 		select {
 
 		// Messages from IRC to Discord
 		case msg := <-b.discordMessagesChan:
-			mapping, ok := b.GetMappingByIRC(msg.IRCChannel)
+			mappings := b.GetMappingsByIRC(msg.IRCChannel)
 
-			if !ok {
+			if len(mappings) == 0 {
 				log.Warnln("Ignoring message sent from an unhandled IRC channel.")
 				continue
 			}
 
-			var avatar string
-			username := msg.Username
-
-			// System messages have no username
-			if username != "" {
-				avatar = b.discord.GetAvatar(b.Config.GuildID, msg.Username)
-				if avatar == "" {
-					// If we don't have a Discord avatar, generate an adorable avatar
-					avatar = strings.ReplaceAll(b.Config.AvatarURL, "${USERNAME}", msg.Username)
-				}
-
-				if len(username) == 1 {
-					// Append usernames with 1 character
-					// This is because Discord doesn't accept single character usernames
-					username += `.` // <- zero width space in here, ayylmao
-				}
+			for _, mapping := range mappingsToDiscord(mappings) {
+				b.sendToDiscord(mapping, msg)
 			}
 
-			content := msg.Message
-
-			// If the message has leading or trailing spaces, or if the message consists
-			// entirely of whitespace, we want Discord to display them as intended,
-			// rather than ignoring it. We surround the content with zero-width spaces
-			// to achieve this. For example, 3 space characters sent from IRC should
-			// render on Discord as 3 space characters too.
-			if content == "" || strings.TrimSpace(content) != content {
-				content = "\u200B" + content + "\u200B"
+		// Messages from Discord to IRC
+		case msg := <-b.discordMessageEventsChan:
+			if msg.PmTarget != "" {
+				b.ircManager.SendMessage(msg.PmTarget, msg)
+				continue
 			}
 
-			// Convert any emoji ye?
-			content = emojiRegex.ReplaceAllStringFunc(content, func(emoji string) string {
-				e, ok := b.emoji[strings.ToLower(emoji[1:len(emoji)-1])]
-				if !ok {
-					return emoji
-				}
-
-				emoji = ":" + e.Name + ":" + e.ID
-				if e.Animated {
-					emoji = "a" + emoji
+			// Do not do anything if we do not have a mapping for the PUBLIC channel,
+			// and send to every IRC channel if it has several
+			for _, mapping := range mappingsToIRC(b.GetMappingsByDiscord(msg.ChannelID)) {
+				if msg.sendsTo(mapping.IRCChannel) {
+					b.ircManager.SendMessage(mapping.IRCChannel, msg)
 				}
-
-				return "<" + emoji + ">"
-			})
-
-			if username == "" {
-				// System messages come straight from the bot
-				if _, err := b.discord.Session.ChannelMessageSend(mapping.DiscordChannel, content); err != nil {
-					log.WithError(err).WithFields(log.Fields{
-						"msg.channel":  mapping.DiscordChannel,
-						"msg.username": username,
-						"msg.content":  content,
-					}).Errorln("could not transmit SYSTEM message to discord")
-				}
-			} else {
-				go func() {
-					_, err := b.discord.transmitter.Send(
-						mapping.DiscordChannel,
-						&discordgo.WebhookParams{
-							Username:  username,
-							AvatarURL: avatar,
-							Content:   content,
-							AllowedMentions: &discordgo.MessageAllowedMentions{
-								// Allow user and role mentions, but not everyone or here mentions
-								Parse: []discordgo.AllowedMentionType{
-									discordgo.AllowedMentionTypeRoles,
-									discordgo.AllowedMentionTypeUsers,
-								},
-							},
-						},
-					)
-
-					if err != nil {
-						log.WithFields(log.Fields{
-							"error":        err,
-							"msg.channel":  mapping.DiscordChannel,
-							"msg.username": username,
-							"msg.avatar":   avatar,
-							"msg.content":  content,
-						}).Errorln("could not transmit message to discord")
-					}
-				}()
 			}
 
-		// Messages from Discord to IRC
-		case msg := <-b.discordMessageEventsChan:
-			mapping, ok := b.GetMappingByDiscord(msg.ChannelID)
-
-			// Do not do anything if we do not have a mapping for the PUBLIC channel
-			if !ok && msg.PmTarget == "" {
-				// log.Warnln("Ignoring message sent from an unhandled Discord channel.")
-				continue
+		// Reactions added or removed on Discord
+		case reaction := <-b.discordReactionsChan:
+			for _, mapping := range mappingsToIRC(b.GetMappingsByDiscord(reaction.ChannelID)) {
+				b.ircManager.HandleReaction(reaction, mapping.IRCChannel)
 			}
 
-			target := msg.PmTarget
-			if target == "" {
-				target = mapping.IRCChannel
+		// Messages deleted on Discord
+		case ids := <-b.discordDeletionsChan:
+			if b.Config.ShowDeletions {
+				b.ircManager.DeleteMessages(ids)
 			}
 
-			b.ircManager.SendMessage(target, msg)
-
 		// Notification to potentially update, or create, a user
 		// We should not receive anything on this channel if we're in Simple Mode
 		case user := <-b.updateUserChan:
@@ -485,11 +919,29 @@ func (b *Bridge) loop() {
 		case userID := <-b.removeUserChan:
 			b.ircManager.DisconnectUser(userID)
 
+		// Functions that use state owned by the loop
+		case f := <-b.loopFuncs:
+			f()
+
+		// Paced redactions of deleted messages
+		case <-b.ircManager.redactTimer:
+			b.ircManager.redactNext()
+
+		// Discord permissions changed, so puppets might need to join or part channels
+		case <-b.updateChannelsChan:
+			b.ircManager.updateChannels()
+
 		// Done!
 		case <-b.done:
-			b.discord.Close()
+			if b.primary == nil {
+				b.discord.Close()
+			}
 			b.ircListener.Quit()
 			b.ircManager.Close()
+			if b.mirrorServer != nil {
+				b.mirrorServer.Close()
+				b.mirror.Close()
+			}
 			close(b.done)
 
 			return
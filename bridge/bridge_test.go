@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigGuildIDs(t *testing.T) {
+	c := &Config{GuildID: "1"}
+	assert.Equal(t, []string{"1"}, c.guildIDs())
+
+	c.ExtraGuildIDs = []string{"2", "1", "", "3"}
+	assert.Equal(t, []string{"1", "2", "3"}, c.guildIDs())
+}
+
+func TestParseMappings(t *testing.T) {
+	mappings, keys, err := parseMappings(map[string]string{
+		"#a key": "1",
+		"#A":     "1",
+		"#b":     "1 irc-to-discord, 2",
+		"#c":     "2 Discord-to-IRC",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"#a": "key"}, keys)
+	assert.Equal(t, []Mapping{
+		{IRCChannel: "#A", DiscordChannel: "1", Direction: DirectionBoth},
+		{IRCChannel: "#b", DiscordChannel: "1", Direction: DirectionIRCToDiscord},
+		{IRCChannel: "#b", DiscordChannel: "2", Direction: DirectionBoth},
+		{IRCChannel: "#c", DiscordChannel: "2", Direction: DirectionDiscordToIRC},
+	}, mappings)
+
+	_, _, err = parseMappings(map[string]string{"#a key": "1", "#a other": "2"})
+	assert.Error(t, err)
+
+	_, _, err = parseMappings(map[string]string{"#a": "1 sideways"})
+	assert.Error(t, err)
+}
+
+func TestAttachmentLimits(t *testing.T) {
+	b := &Bridge{
+		Config: &Config{AttachmentLimits: map[string]int{"#a": 2, "#B": 3}},
+		mappings: []Mapping{
+			{DiscordChannel: "1", IRCChannel: "#a"},
+			{DiscordChannel: "1", IRCChannel: "#b"},
+			{DiscordChannel: "1", IRCChannel: "#c", Direction: DirectionIRCToDiscord},
+			{DiscordChannel: "2", IRCChannel: "#c"},
+		},
+	}
+
+	limits := b.attachmentLimits("1")
+	assert.Equal(t, map[int][]string{2: {"#a"}, 3: {"#b"}}, limits)
+	assert.Equal(t, 3, maxAttachmentLimit(limits))
+
+	limits = b.attachmentLimits("2")
+	assert.Equal(t, map[int][]string{0: {"#c"}}, limits)
+	assert.Equal(t, 0, maxAttachmentLimit(limits))
+	assert.Equal(t, 0, maxAttachmentLimit(nil))
+}
+
+func TestReplyHeader(t *testing.T) {
+	state := discordgo.NewState()
+	assert.NoError(t, state.GuildAdd(&discordgo.Guild{ID: "g", Channels: []*discordgo.Channel{{ID: "1", GuildID: "g"}, {ID: "2", GuildID: "g"}}}))
+
+	b := &Bridge{
+		ircManager:     &IRCManager{relayed: newRelayHistory()},
+		webhookHistory: newWebhookHistory(),
+	}
+	b.discord = &discordBot{Session: &discordgo.Session{State: state}, guildID: "g", bridge: b}
+
+	// #chan is bridged to 1 and 2, and alice spoke in 1
+	b.ircManager.relayed.Sent(relayedMessage{DiscordID: "10", AuthorID: "100", Nick: "alice~d", IRCChannel: "#chan", DiscordChannel: "1"}, "hi")
+	for _, channel := range []string{"1", "2"} {
+		header, ok := b.replyHeader(Mapping{IRCChannel: "#chan", DiscordChannel: channel}, "alice~d")
+		assert.True(t, ok)
+		assert.Equal(t, "-# ↪ replying to `alice~d`: https://discord.com/channels/g/1/10", header)
+	}
+
+	// bob spoke on IRC, and his message has a copy in each Discord channel
+	b.webhookHistory.Add("1", "bob", webhookMessage{ID: "11", IRCChannel: "#chan"})
+	b.webhookHistory.Add("2", "bob", webhookMessage{ID: "12", IRCChannel: "#chan"})
+	header, ok := b.replyHeader(Mapping{IRCChannel: "#chan", DiscordChannel: "2"}, "bob")
+	assert.True(t, ok)
+	assert.Equal(t, "-# ↪ replying to `bob`: https://discord.com/channels/g/2/12", header)
+
+	_, ok = b.replyHeader(Mapping{IRCChannel: "#other", DiscordChannel: "1"}, "bob")
+	assert.False(t, ok)
+}
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Direction is which way a Mapping relays messages
+type Direction string
+
+// Directions a Mapping can have. A blank Direction is DirectionBoth.
+const (
+	DirectionBoth         Direction = "both"
+	DirectionIRCToDiscord Direction = "irc-to-discord"
+	DirectionDiscordToIRC Direction = "discord-to-irc"
+)
+
+func parseDirection(s string) (Direction, error) {
+	switch d := Direction(strings.ToLower(s)); d {
+	case "", DirectionBoth:
+		return DirectionBoth, nil
+	case DirectionIRCToDiscord, DirectionDiscordToIRC:
+		return d, nil
+	}
+	return "", errors.Errorf("unknown direction %q, expected both, irc-to-discord or discord-to-irc", s)
+}
+
+// ToDiscord returns whether messages from IRC are relayed to Discord
+func (d Direction) ToDiscord() bool {
+	return d != DirectionDiscordToIRC
+}
+
+// ToIRC returns whether messages from Discord are relayed to IRC
+func (d Direction) ToIRC() bool {
+	return d != DirectionIRCToDiscord
+}
+
+// Arrow shows the direction, like "→" for messages going from Discord to IRC
+func (d Direction) Arrow() string {
+	switch {
+	case !d.ToDiscord():
+		return "→"
+	case !d.ToIRC():
+		return "←"
+	}
+	return "↔"
+}
+
+// mappingEntry returns the channel_mappings value for one Discord channel,
+// which is followed by its direction unless it is both ways
+func mappingEntry(discordChannel string, direction Direction) string {
+	if direction == "" || direction == DirectionBoth {
+		return discordChannel
+	}
+	return discordChannel + " " + string(direction)
+}
+
+// parseMappingEntry splits a channel_mappings value for one Discord channel
+// into the channel and its direction
+func parseMappingEntry(entry string) (string, Direction, error) {
+	fields := strings.Fields(entry)
+	switch len(fields) {
+	case 1:
+		return fields[0], DirectionBoth, nil
+	case 2:
+		direction, err := parseDirection(fields[1])
+		return fields[0], direction, err
+	}
+	return "", "", errors.Errorf("%q should be a Discord channel ID, optionally followed by a direction", entry)
+}
+
+// mappingsToIRC returns the mappings that relay messages to IRC
+func mappingsToIRC(mappings []Mapping) []Mapping {
+	var result []Mapping
+	for _, mapping := range mappings {
+		if mapping.Direction.ToIRC() {
+			result = append(result, mapping)
+		}
+	}
+	return result
+}
+
+// mappingsToDiscord returns the mappings that relay messages to Discord
+func mappingsToDiscord(mappings []Mapping) []Mapping {
+	var result []Mapping
+	for _, mapping := range mappings {
+		if mapping.Direction.ToDiscord() {
+			result = append(result, mapping)
+		}
+	}
+	return result
+}
+
+// puppetMapping returns a mapping that puppets send to an IRC channel with,
+// or false if puppets shouldn't be in the channel
+func (b *Bridge) puppetMapping(ircChannel string) (Mapping, bool) {
+	mappings := mappingsToIRC(b.GetMappingsByIRC(ircChannel))
+	if len(mappings) == 0 {
+		return Mapping{}, false
+	}
+	return mappings[0], true
+}
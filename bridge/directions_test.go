@@ -0,0 +1,64 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMappingEntry(t *testing.T) {
+	tests := []struct {
+		entry     string
+		channel   string
+		direction Direction
+		err       bool
+	}{
+		{"1", "1", DirectionBoth, false},
+		{"1 both", "1", DirectionBoth, false},
+		{"1 irc-to-discord", "1", DirectionIRCToDiscord, false},
+		{" 1  DISCORD-TO-IRC ", "1", DirectionDiscordToIRC, false},
+		{"1 up", "", "", true},
+		{"1 irc-to-discord extra", "", "", true},
+		{"", "", "", true},
+	}
+
+	for _, tt := range tests {
+		channel, direction, err := parseMappingEntry(tt.entry)
+		if tt.err {
+			assert.Error(t, err, tt.entry)
+			continue
+		}
+		assert.NoError(t, err, tt.entry)
+		assert.Equal(t, tt.channel, channel, tt.entry)
+		assert.Equal(t, tt.direction, direction, tt.entry)
+
+		// Entries made by mappingEntry parse back the same
+		channel, direction, err = parseMappingEntry(mappingEntry(channel, direction))
+		assert.NoError(t, err, tt.entry)
+		assert.Equal(t, tt.channel, channel, tt.entry)
+		assert.Equal(t, tt.direction, direction, tt.entry)
+	}
+}
+
+func TestPuppetMapping(t *testing.T) {
+	b := &Bridge{mappings: []Mapping{
+		{IRCChannel: "#log", DiscordChannel: "1", Direction: DirectionIRCToDiscord},
+		{IRCChannel: "#news", DiscordChannel: "2", Direction: DirectionDiscordToIRC},
+		{IRCChannel: "#mixed", DiscordChannel: "1", Direction: DirectionIRCToDiscord},
+		{IRCChannel: "#mixed", DiscordChannel: "3"},
+	}}
+
+	_, ok := b.puppetMapping("#log")
+	assert.False(t, ok)
+
+	mapping, ok := b.puppetMapping("#news")
+	assert.True(t, ok)
+	assert.Equal(t, "2", mapping.DiscordChannel)
+
+	mapping, ok = b.puppetMapping("#MIXED")
+	assert.True(t, ok)
+	assert.Equal(t, "3", mapping.DiscordChannel)
+
+	assert.Len(t, mappingsToIRC(b.mappings), 2)
+	assert.Len(t, mappingsToDiscord(b.mappings), 3)
+}
@@ -1,10 +1,13 @@
 package bridge
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/42wim/matterbridge/bridge/discord/transmitter"
 	"github.com/qaisjp/go-discord-irc/dstate"
@@ -20,12 +23,30 @@ type discordBot struct {
 	Session *discordgo.Session
 	bridge  *Bridge
 
-	guildID string
+	guildID  string   // the main guild
+	guildIDs []string // all of the bridged guilds, the main guild first
 
-	transmitter *transmitter.Transmitter
+	transmitters map[string]*transmitter.Transmitter // by guild ID
+
+	// Attachments of recent messages, shared by all networks
+	attachments *attachmentCache
+
+	// What was last relayed for recent messages, to compare edits against
+	lastRelayed *relayedText
+
+	reactions *reactionBatcher
+
+	voiceLimiter *voiceLimiter
+
+	// Private threads for PMs with IRC users, if there is a pm_channel
+	pmThreads *pmThreads
+
+	// Timers to unquiet puppets when their Discord timeout ends, by guild and user ID
+	timeoutsMu sync.Mutex
+	timeouts   map[string]*time.Timer
 }
 
-func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
+func newDiscord(bridge *Bridge, botToken string, guildIDs []string) (*discordBot, error) {
 
 	// Create a new Discord session using the provided bot token.
 	session, err := discordgo.New("Bot " + botToken)
@@ -34,18 +55,44 @@ func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
 	}
 	session.StateEnabled = true
 
+	return newDiscordBot(bridge, session, guildIDs, make(map[string]*transmitter.Transmitter), newAttachmentCache()), nil
+}
+
+// newNetworkDiscord returns a discordBot for another IRC network,
+// sharing the Discord session and webhooks of main
+func newNetworkDiscord(bridge *Bridge, main *discordBot) *discordBot {
+	return newDiscordBot(bridge, main.Session, main.guildIDs, main.transmitters, main.attachments)
+}
+
+func newDiscordBot(bridge *Bridge, session *discordgo.Session, guildIDs []string, transmitters map[string]*transmitter.Transmitter, attachments *attachmentCache) *discordBot {
 	discord := &discordBot{
 		Session: session,
 		bridge:  bridge,
 
-		guildID: guildID,
+		guildID:      guildIDs[0],
+		guildIDs:     guildIDs,
+		transmitters: transmitters,
+		attachments:  attachments,
+
+		lastRelayed:  newRelayedText(),
+		voiceLimiter: newVoiceLimiter(),
+		timeouts:     make(map[string]*time.Timer),
+		pmThreads:    newPMThreads(bridge.store),
 	}
+	discord.reactions = newReactionBatcher(discord.flushReactions)
 
 	// These events are all fired in separate goroutines
 	discord.Session.AddHandler(discord.OnReady)
 	discord.Session.AddHandler(discord.onMessageCreate)
 	discord.Session.AddHandler(discord.onMessageUpdate)
+	discord.Session.AddHandler(discord.onMessageDelete)
+	discord.Session.AddHandler(discord.onMessageDeleteBulk)
+	discord.Session.AddHandler(discord.OnMessageReactionAdd)
+	discord.Session.AddHandler(discord.OnMessageReactionRemove)
 	discord.Session.AddHandler(discord.onGuildEmojiUpdate)
+	discord.Session.AddHandler(discord.onVoiceStateUpdate)
+	discord.Session.AddHandler(discord.onInteractionCreate)
+	discord.Session.AddHandler(discord.onChannelUpdate)
 
 	if !bridge.Config.SimpleMode {
 		discord.Session.AddHandler(discord.onMemberListChunk)
@@ -54,17 +101,25 @@ func newDiscord(bridge *Bridge, botToken, guildID string) (*discordBot, error) {
 		discord.Session.AddHandler(discord.OnPresencesReplace)
 		discord.Session.AddHandler(discord.OnPresenceUpdate)
 		discord.Session.AddHandler(discord.OnTypingStart)
-		discord.Session.AddHandler(discord.OnMessageReactionAdd)
+		discord.Session.AddHandler(discord.onGuildBanAdd)
+		discord.Session.AddHandler(discord.onGuildBanRemove)
+		discord.Session.AddHandler(discord.onMemberTimeout)
+		discord.Session.AddHandler(discord.onGuildRoleUpdate)
+		discord.Session.AddHandler(discord.onGuildRoleDelete)
+		discord.Session.AddHandler(discord.onChannelPermissionsUpdate)
 	}
 
-	return discord, nil
+	return discord
 }
 
 func (d *discordBot) Open() error {
-	d.transmitter = transmitter.New(d.Session, d.guildID, "irc-bridge", true)
-	d.transmitter.Log = logrus.NewEntry(logrus.StandardLogger())
-	if err := d.transmitter.RefreshGuildWebhooks(nil); err != nil {
-		return fmt.Errorf("failed to refresh guild webhooks: %w", err)
+	for _, guildID := range d.guildIDs {
+		t := transmitter.New(d.Session, guildID, "irc-bridge", true)
+		t.Log = logrus.NewEntry(logrus.StandardLogger())
+		if err := t.RefreshGuildWebhooks(nil); err != nil {
+			return fmt.Errorf("failed to refresh webhooks of guild %s: %w", guildID, err)
+		}
+		d.transmitters[guildID] = t
 	}
 
 	d.Session.Identify.Intents = discordgo.MakeIntent(discordgo.IntentsAll)
@@ -106,12 +161,12 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 	}
 
 	// Ignore messages sent from our webhooks
-	if d.transmitter.HasWebhook(m.Author.ID) {
+	if d.isWebhook(m.Author.ID) {
 		return
 	}
 
-	// If the message is "ping" reply with "Pong!"
-	if m.Content == "ping" {
+	// If the message is "ping" reply with "Pong!", once for all networks
+	if m.Content == "ping" && d.bridge.primary == nil {
 		_, err := s.ChannelMessageSend(m.ChannelID, "Pong!")
 		if err != nil {
 			log.Warningln("Could not respond to Discord ping message", err.Error())
@@ -120,6 +175,11 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 
 	// HACK: this is before d.ParseText so that the existing <@uid> translation logic can be used
 	if m.MessageReference != nil && m.MessageReference.ChannelID == m.ChannelID {
+		// Every network is handed the same message, so change a copy
+		reply := *m
+		reply.Mentions = append([]*discordgo.User{}, m.Mentions...)
+		m = &reply
+
 		prefix := "[reply]"
 		msg, err := dstate.ChannelMessage(d.Session, m.MessageReference.ChannelID, m.MessageReference.MessageID)
 		if err == nil {
@@ -144,12 +204,42 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 		content = content[1 : len(m.Content)-1]
 	}
 
+	// Stickers, embeds and polls are rendered as extra lines
+	extras := renderMessageExtras(m)
+
+	// Remember what we're relaying, so that edits can be compared against it
+	text := strings.Join(append([]string{content}, extras...), "\n")
+	previous, known := d.lastRelayed.Swap(m.ID, text)
+
+	attachments := m.Attachments
 	if wasEdit {
-		if isAction {
+		diffed := false
+		if known {
+			// Discord also sends updates when it unfurls links, but if the
+			// text hasn't changed there's nothing to relay
+			if previous == text {
+				return
+			}
+
+			// Attachments can't be added by editing, and were already relayed
+			attachments = nil
+
+			if diff, ok := editDiff(previous, text); ok {
+				content = diff
+				extras = nil
+				diffed = true
+			}
+		}
+
+		if isAction && !diffed {
 			content = "/me " + content
 		}
 
-		content = "[edit] " + content
+		if content != "" {
+			content = "[edit] " + content
+		} else if len(extras) > 0 {
+			extras[0] = "[edit] " + extras[0]
+		}
 	}
 
 	if strings.Count(content, "||") >= 2 {
@@ -157,12 +247,30 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 	}
 
 	pmTarget := ""
-	// Blank guild means that it's a PM
-	if m.GuildID == "" {
+	if thread, ok := d.pmThreads.Get(m.ChannelID); ok {
+		// Replies in a PM thread go to who the thread is with
+		if m.Author.ID != thread.UserID {
+			return
+		}
+		pmTarget = thread.Nick
+	} else if m.GuildID == "" {
+		// Blank guild means that it's a PM.
+		// Commands and mistakes are answered by the main network,
+		// and each network only sends PMs with its own discriminator.
+		if d.bridge.primary == nil {
+			if reply, ok := d.dmCommand(m.Author, content); ok {
+				_, _ = d.Session.ChannelMessageSend(m.ChannelID, reply)
+				return
+			}
+		}
+
 		pmTarget, content = pmTargetFromContent(content, d.bridge.Config.Discriminator)
 		// if the target could not be deduced. tell them this.
 		switch pmTarget {
 		case "":
+			if d.bridge.primary != nil {
+				return
+			}
 			_, _ = d.Session.ChannelMessageSend(
 				m.ChannelID,
 				fmt.Sprintf(
@@ -176,78 +284,138 @@ func (d *discordBot) publishMessage(s *discordgo.Session, m *discordgo.Message,
 		}
 	}
 
-	d.bridge.discordMessageEventsChan <- &DiscordMessage{
-		Message:  m,
-		Content:  content,
-		IsAction: isAction,
-		PmTarget: pmTarget,
+	// Messages consisting only of a sticker, embed, poll or attachment
+	// have no content, so don't send a blank line for them
+	if content != "" {
+		d.bridge.discordMessageEventsChan <- &DiscordMessage{
+			Message:  m,
+			Content:  content,
+			IsAction: isAction,
+			PmTarget: pmTarget,
+		}
 	}
 
-	for _, attachment := range m.Attachments {
+	for _, extra := range extras {
 		d.bridge.discordMessageEventsChan <- &DiscordMessage{
 			Message:  m,
-			Content:  attachment.URL,
-			IsAction: isAction,
+			Content:  extra,
 			PmTarget: pmTarget,
 		}
 	}
-}
 
-func (d *discordBot) publishReaction(s *discordgo.Session, r *discordgo.MessageReaction) {
-	if s.State.User == nil {
+	// Each IRC channel can have its own limit, and PMs have none
+	limits := map[int][]string{0: nil}
+	if pmTarget == "" {
+		limits = d.bridge.attachmentLimits(m.ChannelID)
+	}
+
+	// Only fetch and mirror attachments that this network relays
+	if len(attachments) == 0 || len(limits) == 0 {
 		return
 	}
 
-	user, err := s.User(r.UserID)
+	attachments, descriptions := d.attachments.Prepare(m.ID, func() ([]*discordgo.MessageAttachment, map[string]string) {
+		return d.prepareAttachments(m, attachments)
+	})
+
+	for limit, channels := range limits {
+		for _, line := range renderAttachments(attachments, descriptions, limit) {
+			d.bridge.discordMessageEventsChan <- &DiscordMessage{
+				Message:     m,
+				Content:     line,
+				IsAction:    isAction,
+				PmTarget:    pmTarget,
+				IRCChannels: channels,
+			}
+		}
+	}
+}
+
+// prepareAttachments fetches the descriptions of a message's attachments,
+// and mirrors as many as any network relays
+func (d *discordBot) prepareAttachments(m *discordgo.Message, attachments []*discordgo.MessageAttachment) ([]*discordgo.MessageAttachment, map[string]string) {
+	descriptions := d.attachmentDescriptions(m)
+	if d.bridge.mirror == nil {
+		return attachments, descriptions
+	}
+
+	limits := make(map[int][]string)
+	for _, network := range d.bridge.allNetworks() {
+		for limit, channels := range network.attachmentLimits(m.ChannelID) {
+			limits[limit] = append(limits[limit], channels...)
+		}
+	}
+	return d.mirrorAttachments(attachments, maxAttachmentLimit(limits)), descriptions
+}
+
+// attachmentDescriptions fetches the alt text of a message's attachments,
+// by attachment ID. discordgo doesn't decode it, so the message is fetched
+// again and decoded here.
+func (d *discordBot) attachmentDescriptions(m *discordgo.Message) map[string]string {
+	endpoint := discordgo.EndpointChannelMessage(m.ChannelID, m.ID)
+	response, err := d.Session.RequestWithBucketID("GET", endpoint, nil, discordgo.EndpointChannelMessage(m.ChannelID, ""))
 	if err != nil {
-		log.Errorln(err)
-		return
+		log.WithError(err).WithField("message", m.ID).Warnln("could not fetch attachment descriptions")
+		return nil
 	}
 
-	// Bridge needs these for mapping
-	m := &discordgo.Message{
-		ChannelID: r.ChannelID,
-		Author:    user,
-		GuildID:   r.GuildID,
+	var msg struct {
+		Attachments []struct {
+			ID          string `json:"id"`
+			Description string `json:"description"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(response, &msg); err != nil {
+		log.WithError(err).WithField("message", m.ID).Warnln("could not decode attachment descriptions")
+		return nil
 	}
 
-	originalMessage, err := dstate.ChannelMessage(d.Session, r.ChannelID, r.MessageID)
-	reactionTarget := ""
-	if err == nil {
-		// TODO 1: could add extra logic to figure out what length is needed to disambiguate
-		// TODO 2: length should not cause command to exceed the max command length
+	descriptions := make(map[string]string, len(msg.Attachments))
+	for _, a := range msg.Attachments {
+		if a.Description != "" {
+			descriptions[a.ID] = a.Description
+		}
+	}
+	return descriptions
+}
 
-		// HACK: this is before d.ParseText so that the existing <@uid> translation logic can be used
-		username := userToMention(originalMessage.Author)
-		if !originalMessage.Author.Bot {
-			// HACK: theoretically could already be there, thereotically not a big problem
-			originalMessage.Mentions = append(originalMessage.Mentions, originalMessage.Author)
+// mirrorAttachments returns a copy of attachments, with the URLs of the
+// first limit attachments (or all, if limit is 0) replaced by mirrored ones.
+// The CDN URL is kept if an attachment can't be mirrored.
+func (d *discordBot) mirrorAttachments(attachments []*discordgo.MessageAttachment, limit int) []*discordgo.MessageAttachment {
+	mirrored := make([]*discordgo.MessageAttachment, len(attachments))
+	for i, a := range attachments {
+		mirrored[i] = a
+		if limit > 0 && i >= limit {
+			continue
 		}
-		originalMessage.Content = fmt.Sprintf(
-			" to <%s> %s",
-			username,
-			// Truncate messages to just 40 characters so reactions to long messages
-			// don't pollute the IRC log. Similarly, replace newlines with spaces
-			// so that any reactions to messages with a newline within the first 40
-			// characters don't cause multiple IRC messages to be sent.
-			strings.ReplaceAll(TruncateString(40, originalMessage.Content), "\n", " "),
-		)
 
-		reactionTarget = d.ParseText(originalMessage)
+		url, err := d.bridge.mirror.Store(a.ID, a.URL, a.Filename)
+		if err != nil {
+			log.WithError(err).WithField("attachment", a.ID).Warnln("could not mirror attachment")
+			continue
+		}
+
+		copied := *a
+		copied.URL = url
+		mirrored[i] = &copied
+	}
+	return mirrored
+}
+
+func (d *discordBot) publishReaction(s *discordgo.Session, r *discordgo.MessageReaction, added bool) {
+	if s.State.User == nil {
+		return
 	}
 
-	emoji := r.Emoji.Name
-	if r.Emoji.ID != "" {
-		// Custom emoji
-		emoji = fmt.Sprint(":", emoji, ":")
+	// Ignore reactions from the bot itself
+	if r.UserID == s.State.User.ID {
+		return
 	}
-	content := fmt.Sprint("reacted with ", emoji, reactionTarget)
 
-	d.bridge.discordMessageEventsChan <- &DiscordMessage{
-		Message:  m,
-		Content:  content,
-		IsAction: true,
-		PmTarget: "",
+	d.bridge.discordReactionsChan <- DiscordReaction{
+		MessageReaction: r,
+		Added:           added,
 	}
 }
 
@@ -277,7 +445,7 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 			nick := user.Username
 
 			// If we can get their member + nick, set nick to the real nick
-			member, err := d.Session.State.Member(d.guildID, user.ID)
+			member, err := d.Session.State.Member(d.messageGuild(m), user.ID)
 			if err == nil && member.Nick != "" {
 				nick = member.Nick
 			}
@@ -312,7 +480,7 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 
 	// Copied from message.go ContentWithMoreMentionsReplaced(s)
 	for _, roleID := range m.MentionRoles {
-		role, err := d.Session.State.Role(d.guildID, roleID)
+		role, err := d.Session.State.Role(d.messageGuild(m), roleID)
 		if err != nil || !role.Mentionable {
 			continue
 		}
@@ -354,7 +522,7 @@ func (d *discordBot) ParseText(m *discordgo.Message) string {
 		// Strip enclosing identifiers
 		roleID := str[3 : len(str)-1]
 
-		role, err := d.Session.State.Role(d.bridge.Config.GuildID, roleID)
+		role, err := d.Session.State.Role(d.messageGuild(m), roleID)
 		if err == nil {
 			return "@" + role.Name
 		} else if err == discordgo.ErrStateNotFound {
@@ -388,7 +556,7 @@ func (d *discordBot) handlePresenceUpdate(uid string, status discordgo.Status, f
 	}
 
 	// Otherwise get their GuildMember object...
-	user, err := d.Session.State.Member(d.guildID, uid)
+	user, _, err := d.member(uid)
 	if err != nil {
 		log.Println(errors.Wrap(err, "get member from state in handlePresenceUpdate failed"))
 		return
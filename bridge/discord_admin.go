@@ -0,0 +1,221 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// adminPermissions are the permissions that allow managing the bridge from Discord
+const adminPermissions = discordgo.PermissionManageChannels | discordgo.PermissionAdministrator
+
+var manageChannels int64 = discordgo.PermissionManageChannels
+
+// bridgeCommand is the /bridge application command, for admins to manage mappings
+var bridgeCommand = &discordgo.ApplicationCommand{
+	Name:                     "bridge",
+	Description:              "Manage which channels are bridged to IRC",
+	DefaultMemberPermissions: &manageChannels,
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "add",
+			Description: "Bridge a channel to an IRC channel",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "irc_channel",
+					Description: "The IRC channel, like #channel",
+					Required:    true,
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "key",
+					Description: "The IRC channel's key (password), if it has one",
+				},
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Description:  "The Discord channel, this channel by default",
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "direction",
+					Description: "Which way messages go, both ways by default",
+					Choices: []*discordgo.ApplicationCommandOptionChoice{
+						{Name: "Both ways", Value: string(DirectionBoth)},
+						{Name: "IRC to Discord only", Value: string(DirectionIRCToDiscord)},
+						{Name: "Discord to IRC only", Value: string(DirectionDiscordToIRC)},
+					},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "network",
+					Description: "The IRC network's irc_server_name, if the bridge connects to several",
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "remove",
+			Description: "Stop bridging a channel to IRC",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:         discordgo.ApplicationCommandOptionChannel,
+					Name:         "channel",
+					Description:  "The Discord channel, this channel by default",
+					ChannelTypes: []discordgo.ChannelType{discordgo.ChannelTypeGuildText},
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "irc_channel",
+					Description: "Only stop bridging to this IRC channel, if it's bridged to several",
+				},
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "network",
+					Description: "The IRC network's irc_server_name, if the channel is bridged to several",
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "list",
+			Description: "List the bridged channels",
+		},
+	},
+}
+
+// isAdmin returns whether the member that used an interaction can manage the bridge
+func (d *discordBot) isAdmin(i *discordgo.Interaction) bool {
+	if i.Member == nil {
+		return false
+	}
+
+	if i.Member.Permissions&adminPermissions != 0 {
+		return true
+	}
+
+	for _, role := range i.Member.Roles {
+		for _, admin := range d.bridge.Config.AdminRoles {
+			if role == admin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *discordBot) onBridgeCommand(i *discordgo.Interaction, sub *discordgo.ApplicationCommandInteractionDataOption) {
+	if !d.isAdmin(i) {
+		d.respond(i, "You need the Manage Channels permission, or an admin role, to do that.")
+		return
+	}
+
+	options := commandOptions(sub)
+	channelID, _ := bridgeCommandTarget(i, sub)
+
+	switch sub.Name {
+	case "add":
+		ircChannel := options["irc_channel"].StringValue()
+		if !validIRCChannel(ircChannel) {
+			d.respond(i, fmt.Sprintf("`%s` isn't a valid IRC channel name.", ircChannel))
+			return
+		}
+
+		irc := ircChannel
+		if key, ok := options["key"]; ok && key.StringValue() != "" {
+			if strings.ContainsAny(key.StringValue(), " ,") {
+				d.respond(i, "IRC channel keys can't contain spaces or commas.")
+				return
+			}
+			irc += " " + key.StringValue()
+		}
+
+		direction := DirectionBoth
+		if option, ok := options["direction"]; ok {
+			var err error
+			if direction, err = parseDirection(option.StringValue()); err != nil {
+				d.respond(i, "Could not bridge the channel: "+err.Error())
+				return
+			}
+		}
+
+		if err := d.bridge.AddMapping(irc, channelID, direction); err != nil {
+			d.respond(i, "Could not bridge the channel: "+err.Error())
+			return
+		}
+		d.respond(i, fmt.Sprintf("Bridged <#%s> %s %s.", channelID, direction.Arrow(), ircChannel))
+
+	case "remove":
+		ircChannel := ""
+		if option, ok := options["irc_channel"]; ok {
+			ircChannel = option.StringValue()
+		}
+
+		if err := d.bridge.RemoveMapping(channelID, ircChannel); err != nil {
+			d.respond(i, "Could not stop bridging the channel: "+err.Error())
+			return
+		}
+
+		if ircChannel != "" {
+			d.respond(i, fmt.Sprintf("<#%s> isn't bridged to %s any more.", channelID, ircChannel))
+			return
+		}
+		d.respond(i, fmt.Sprintf("<#%s> isn't bridged to IRC any more.", channelID))
+
+	case "list":
+		var lines []string
+		networks := d.bridge.allNetworks()
+		for _, n := range networks {
+			for _, mapping := range n.mappings {
+				line := fmt.Sprintf("<#%s> %s %s", mapping.DiscordChannel, mapping.Direction.Arrow(), mapping.IRCChannel)
+				if len(networks) > 1 {
+					line += " on " + n.Config.Discriminator
+				}
+				lines = append(lines, line)
+			}
+		}
+		sort.Strings(lines)
+
+		if len(lines) == 0 {
+			d.respond(i, "No channels are bridged.")
+			return
+		}
+		d.respond(i, truncateMessage(strings.Join(lines, "\n")))
+	}
+}
+
+// commandOptions returns the options of a subcommand by name
+func commandOptions(sub *discordgo.ApplicationCommandInteractionDataOption) map[string]*discordgo.ApplicationCommandInteractionDataOption {
+	options := make(map[string]*discordgo.ApplicationCommandInteractionDataOption)
+	for _, option := range sub.Options {
+		options[option.Name] = option
+	}
+	return options
+}
+
+// bridgeCommandTarget returns the Discord channel a /bridge command is for,
+// and the network it names, if any
+func bridgeCommandTarget(i *discordgo.Interaction, sub *discordgo.ApplicationCommandInteractionDataOption) (channelID, network string) {
+	options := commandOptions(sub)
+
+	channelID = i.ChannelID
+	if option, ok := options["channel"]; ok {
+		channelID = option.ChannelValue(nil).ID
+	}
+	if option, ok := options["network"]; ok {
+		network = option.StringValue()
+	}
+	return channelID, network
+}
+
+// validIRCChannel returns whether name is a valid IRC channel name
+func validIRCChannel(name string) bool {
+	return len(name) > 1 && len(name) <= 50 &&
+		(name[0] == '#' || name[0] == '&') &&
+		!strings.ContainsAny(name, " ,\x07\r\n")
+}
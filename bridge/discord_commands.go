@@ -0,0 +1,349 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// discordMaxMessageLength is the longest message Discord allows
+const discordMaxMessageLength = 2000
+
+// ircCommand is the /irc application command, for looking things up on IRC
+var ircCommand = &discordgo.ApplicationCommand{
+	Name:        "irc",
+	Description: "Look things up on the IRC side of the bridge",
+	Options: []*discordgo.ApplicationCommandOption{
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "names",
+			Description: "List the users in this channel on IRC",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "whois",
+			Description: "Look up an IRC user",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "nick",
+					Description: "The IRC nick to look up",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "topic",
+			Description: "Show the topic of this channel on IRC",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "nick",
+			Description: "Show your nick on IRC",
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "claim",
+			Description: "Choose your nick on IRC, instead of using your display name",
+			Options: []*discordgo.ApplicationCommandOption{
+				{
+					Type:        discordgo.ApplicationCommandOptionString,
+					Name:        "nick",
+					Description: "The nick you want, without the suffix",
+					Required:    true,
+				},
+			},
+		},
+		{
+			Type:        discordgo.ApplicationCommandOptionSubCommand,
+			Name:        "unclaim",
+			Description: "Go back to using your display name as your nick on IRC",
+		},
+	},
+}
+
+// registerCommands registers the application commands in each bridged guild
+func (d *discordBot) registerCommands(appID string) {
+	for _, guildID := range d.guildIDs {
+		for _, cmd := range []*discordgo.ApplicationCommand{ircCommand, bridgeCommand} {
+			if _, err := d.Session.ApplicationCommandCreate(appID, guildID, cmd); err != nil {
+				log.WithError(err).Warnf("could not register the /%s command in guild %s, does the bot have the applications.commands scope?", cmd.Name, guildID)
+			}
+		}
+	}
+}
+
+func (d *discordBot) onInteractionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+
+	data := i.ApplicationCommandData()
+	if len(data.Options) == 0 {
+		return
+	}
+
+	sub := data.Options[0]
+
+	// Every network sees the interaction, but only one should answer it
+	channelID, network := i.ChannelID, ""
+	if data.Name == bridgeCommand.Name {
+		channelID, network = bridgeCommandTarget(i.Interaction, sub)
+	}
+	target, ok := d.bridge.commandNetwork(channelID, network)
+	if !ok {
+		// The main network answers for networks that don't exist
+		if d.bridge.primary == nil {
+			d.respond(i.Interaction, fmt.Sprintf("There is no IRC network called `%s`.", network))
+		}
+		return
+	} else if target != d.bridge {
+		return
+	}
+
+	if data.Name == bridgeCommand.Name {
+		d.onBridgeCommand(i.Interaction, sub)
+		return
+	} else if data.Name != ircCommand.Name {
+		return
+	}
+
+	switch sub.Name {
+	case "names":
+		d.respond(i.Interaction, d.ircNames(i.ChannelID))
+	case "topic":
+		d.respond(i.Interaction, d.ircTopic(i.ChannelID))
+	case "nick":
+		d.respond(i.Interaction, d.ircNick(interactionUser(i.Interaction)))
+	case "claim":
+		if len(sub.Options) == 0 {
+			return
+		}
+		d.respond(i.Interaction, d.claimNick(interactionUser(i.Interaction), sub.Options[0].StringValue()))
+	case "unclaim":
+		d.respond(i.Interaction, d.claimNick(interactionUser(i.Interaction), ""))
+	case "whois":
+		if len(sub.Options) == 0 {
+			return
+		}
+
+		// WHOIS replies can take a while, so tell Discord we're working on it
+		err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseDeferredChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{Flags: discordgo.MessageFlagsEphemeral},
+		})
+		if err != nil {
+			log.WithError(err).Errorln("could not respond to /irc whois")
+			return
+		}
+
+		content := d.ircWhois(sub.Options[0].StringValue())
+		if _, err := s.InteractionResponseEdit(i.Interaction, &discordgo.WebhookEdit{
+			Content:         &content,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			log.WithError(err).Errorln("could not respond to /irc whois")
+		}
+	}
+}
+
+// respond replies to an interaction with a message only the caller can see
+func (d *discordBot) respond(i *discordgo.Interaction, content string) {
+	err := d.Session.InteractionRespond(i, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content:         content,
+			Flags:           discordgo.MessageFlagsEphemeral,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		},
+	})
+	if err != nil {
+		log.WithError(err).Errorln("could not respond to interaction")
+	}
+}
+
+// interactionUser returns who used an interaction, in a guild or in DMs
+func interactionUser(i *discordgo.Interaction) *discordgo.User {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+const notBridged = "This channel isn't bridged to IRC."
+
+func (d *discordBot) ircNames(channelID string) string {
+	return d.eachIRCChannel(channelID, func(ircChannel string) string {
+		channel, ok := d.bridge.ircListener.GetChannel(ircChannel)
+		if !ok {
+			return fmt.Sprintf("I'm not in %s on IRC right now.", ircChannel)
+		}
+
+		var nicks []string
+		channel.IterUsers(func(nick string, u *irc.User) {
+			nicks = append(nicks, nick)
+		})
+		sort.Slice(nicks, func(i, j int) bool {
+			return strings.ToLower(nicks[i]) < strings.ToLower(nicks[j])
+		})
+
+		return fmt.Sprintf("%d users in %s on IRC:\n```\n%s\n```", len(nicks), ircChannel, strings.Join(nicks, " "))
+	})
+}
+
+func (d *discordBot) ircTopic(channelID string) string {
+	return d.eachIRCChannel(channelID, func(ircChannel string) string {
+		topic, ok := d.bridge.ircListener.ChannelTopic(ircChannel)
+		if !ok || topic == "" {
+			return fmt.Sprintf("%s has no topic on IRC.", ircChannel)
+		}
+
+		return fmt.Sprintf("Topic of %s on IRC:\n```\n%s\n```", ircChannel, topic)
+	})
+}
+
+// eachIRCChannel describes each IRC channel a Discord channel is bridged to
+func (d *discordBot) eachIRCChannel(channelID string, describe func(ircChannel string) string) string {
+	mappings := d.bridge.GetMappingsByDiscord(channelID)
+	if len(mappings) == 0 {
+		return notBridged
+	}
+
+	var parts []string
+	for _, mapping := range mappings {
+		parts = append(parts, describe(mapping.IRCChannel))
+	}
+	return truncateMessage(strings.Join(parts, "\n"))
+}
+
+func (d *discordBot) ircNick(user *discordgo.User) string {
+	if user == nil {
+		return "I don't know who you are."
+	}
+
+	// The connections belong to the bridge loop
+	var nick string
+	d.bridge.inLoop(func() {
+		if con, ok := d.bridge.ircManager.ircConnections[user.ID]; ok {
+			nick = con.nick
+		}
+	})
+	if nick != "" {
+		return fmt.Sprintf("Your nick on IRC is `%s`.", nick)
+	}
+
+	if d.bridge.Config.SimpleMode {
+		return fmt.Sprintf("Your messages are sent to IRC by `%s`, prefixed with your name.", d.bridge.ircListener.GetNick())
+	}
+	return "You aren't connected to IRC right now. You will be when you're online and can see a bridged channel."
+}
+
+// claimNick claims an IRC nick for a user on every network, or removes
+// their claim if nick is blank, and renames their puppets
+func (d *discordBot) claimNick(user *discordgo.User, nick string) string {
+	if user == nil {
+		return "I don't know who you are."
+	}
+
+	networks := d.bridge.allNetworks()
+	failed := func(network *Bridge, err error) string {
+		if len(networks) > 1 {
+			return fmt.Sprintf("Could not change your nick on %s: %s.", network.Config.Discriminator, err)
+		}
+		return fmt.Sprintf("Could not change your nick: %s.", err)
+	}
+
+	// Check the nick can be claimed on every network before claiming it on
+	// any. The puppets belong to each network's loop.
+	previous := make([]string, len(networks))
+	for i, network := range networks {
+		var err error
+		network.inLoop(func() {
+			err = network.ircManager.checkNickClaim(user.ID, nick)
+		})
+		if err != nil {
+			return failed(network, err)
+		}
+		previous[i], _ = network.ircManager.nickClaims.Get(user.ID)
+	}
+
+	for i, network := range networks {
+		if err := network.ircManager.nickClaims.Set(user.ID, nick); err != nil {
+			// Put back the claims on the networks already changed
+			for j := range networks[:i] {
+				if err := networks[j].ircManager.nickClaims.Set(user.ID, previous[j]); err != nil {
+					log.WithError(err).WithField("network", networks[j].Config.Discriminator).Errorln("could not restore nick claim")
+				}
+			}
+			return failed(network, err)
+		}
+	}
+
+	// Rename their puppets, if they have them
+	if member, _, err := d.member(user.ID); err == nil {
+		for _, network := range networks {
+			network.discord.handleMemberUpdate(member, false)
+		}
+	}
+
+	if nick == "" {
+		return "Your nick on IRC will be based on your display name again."
+	}
+	return fmt.Sprintf("Your nick on IRC will be `%s`.", nick+d.bridge.Config.Suffix)
+}
+
+// dmCommand runs a command sent to the bot in a private message, like
+// "!claim nick", returning whether the message was a command
+func (d *discordBot) dmCommand(user *discordgo.User, content string) (string, bool) {
+	fields := strings.Fields(content)
+	if len(fields) == 0 {
+		return "", false
+	}
+
+	switch strings.ToLower(fields[0]) {
+	case "!claim":
+		if len(fields) != 2 {
+			return "Usage: `!claim <nick>`", true
+		}
+		return d.claimNick(user, fields[1]), true
+	case "!unclaim":
+		return d.claimNick(user, ""), true
+	}
+	return "", false
+}
+
+func (d *discordBot) ircWhois(nick string) string {
+	reply, err := d.bridge.ircListener.LookupWhois(nick)
+	if err == errNoSuchNick {
+		return fmt.Sprintf("`%s` isn't on IRC.", nick)
+	} else if err != nil {
+		return fmt.Sprintf("Could not look up `%s`: %s", nick, err)
+	}
+
+	return truncateMessage("```\n" + reply.String() + "\n```")
+}
+
+// truncateMessage shortens a message to fit in a Discord message,
+// closing a code block if one was cut off
+func truncateMessage(content string) string {
+	if len(content) <= discordMaxMessageLength {
+		return content
+	}
+
+	suffix := "…"
+	if strings.HasSuffix(content, "```") {
+		suffix = "…\n```"
+	}
+
+	cut := discordMaxMessageLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(content[cut]) {
+		cut--
+	}
+	return content[:cut] + suffix
+}
@@ -16,8 +16,20 @@ func (d *discordBot) onMessageUpdate(s *discordgo.Session, m *discordgo.MessageU
 	d.publishMessage(s, m.Message, true)
 }
 
+func (d *discordBot) onMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	d.bridge.discordDeletionsChan <- []string{m.ID}
+}
+
+func (d *discordBot) onMessageDeleteBulk(s *discordgo.Session, m *discordgo.MessageDeleteBulk) {
+	d.bridge.discordDeletionsChan <- m.Messages
+}
+
 func (d *discordBot) OnMessageReactionAdd(s *discordgo.Session, m *discordgo.MessageReactionAdd) {
-	d.publishReaction(s, m.MessageReaction)
+	d.publishReaction(s, m.MessageReaction, true)
+}
+
+func (d *discordBot) OnMessageReactionRemove(s *discordgo.Session, m *discordgo.MessageReactionRemove) {
+	d.publishReaction(s, m.MessageReaction, false)
 }
 
 // onMemberListChunk is fired in response to our GuildMembers request in OnReady
@@ -51,7 +63,7 @@ func (d *discordBot) OnPresenceUpdate(s *discordgo.Session, m *discordgo.Presenc
 func (d *discordBot) OnTypingStart(s *discordgo.Session, m *discordgo.TypingStart) {
 	status := discordgo.StatusOffline
 
-	p, err := d.Session.State.Presence(d.guildID, m.UserID)
+	p, err := d.Session.State.Presence(m.GuildID, m.UserID)
 	if err != nil {
 		log.Println(errors.Wrap(err, "get presence from in OnTypingStart failed"))
 		// return
@@ -64,16 +76,26 @@ func (d *discordBot) OnTypingStart(s *discordgo.Session, m *discordgo.TypingStar
 }
 
 func (d *discordBot) OnReady(s *discordgo.Session, m *discordgo.Ready) {
-	// Fires a GuildMembersChunk event
-	err := d.Session.RequestGuildMembers(d.guildID, "", 0, "", true)
-	if err != nil {
-		log.Warningln(errors.Wrap(err, "could not request guild members").Error())
-		return
+	// Other networks get the same events, so only the main one asks for them
+	main := d.bridge.primary == nil
+	if main {
+		d.registerCommands(m.User.ID)
 	}
 
-	emoji, err := d.Session.GuildEmojis(d.guildID)
-	if err == nil {
-		d.setGuildEmoji(d.guildID, emoji)
+	for _, guildID := range d.guildIDs {
+		// Fires a GuildMembersChunk event
+		if main {
+			err := d.Session.RequestGuildMembers(guildID, "", 0, "", true)
+			if err != nil {
+				log.Warningln(errors.Wrap(err, "could not request guild members").Error())
+				continue
+			}
+		}
+
+		emoji, err := d.Session.GuildEmojis(guildID)
+		if err == nil {
+			d.setGuildEmoji(guildID, emoji)
+		}
 	}
 }
 
@@ -82,17 +104,30 @@ func (d *discordBot) onGuildEmojiUpdate(s *discordgo.Session, m *discordgo.Guild
 }
 
 func (d *discordBot) setGuildEmoji(guild string, emoji []*discordgo.Emoji) {
-	d.bridge.emoji = make(map[string]*discordgo.Emoji)
+	if !d.isGuild(guild) {
+		return
+	}
+
+	byName := make(map[string]*discordgo.Emoji)
 	for _, e := range emoji {
-		d.bridge.emoji[strings.ToLower(e.Name)] = e
+		byName[strings.ToLower(e.Name)] = e
 	}
+
+	d.bridge.emojiMu.Lock()
+	d.bridge.emoji[guild] = byName
+	d.bridge.emojiMu.Unlock()
 }
 
 func (d *discordBot) handleMemberUpdate(m *discordgo.Member, forceOnline bool) {
 	status := discordgo.StatusOnline
 
+	// People in several guilds use their nick from the first one they're in
+	if member, _, err := d.member(m.User.ID); err == nil {
+		m = member
+	}
+
 	if !forceOnline {
-		presence, err := d.Session.State.Presence(d.guildID, m.User.ID)
+		presence, err := d.presence(m.User.ID)
 		if err != nil {
 			// This error is usually triggered on first run because it represents offline
 			if err != discordgo.ErrStateNotFound {
@@ -0,0 +1,103 @@
+package bridge
+
+import (
+	"github.com/42wim/matterbridge/bridge/discord/transmitter"
+	"github.com/bwmarrin/discordgo"
+)
+
+// isGuild returns whether a guild is one of the bridged guilds
+func (d *discordBot) isGuild(guildID string) bool {
+	for _, id := range d.guildIDs {
+		if id == guildID {
+			return true
+		}
+	}
+	return false
+}
+
+// channelGuild returns the guild a channel is in,
+// or the main guild if the channel isn't known
+func (d *discordBot) channelGuild(channelID string) string {
+	if channel, err := d.Session.State.Channel(channelID); err == nil && channel.GuildID != "" {
+		return channel.GuildID
+	}
+	return d.guildID
+}
+
+// messageGuild returns the guild a message was sent in,
+// or the main guild if it was a DM
+func (d *discordBot) messageGuild(m *discordgo.Message) string {
+	if m.GuildID != "" {
+		return m.GuildID
+	}
+	return d.guildID
+}
+
+// member returns a user's member, and its guild, from the first
+// bridged guild they are in
+func (d *discordBot) member(userID string) (member *discordgo.Member, guildID string, err error) {
+	err = discordgo.ErrStateNotFound
+	for _, guildID := range d.guildIDs {
+		if member, err = d.Session.State.Member(guildID, userID); err == nil {
+			return member, guildID, nil
+		}
+	}
+	return nil, "", err
+}
+
+// presence returns a user's presence from the first bridged guild they are in
+func (d *discordBot) presence(userID string) (presence *discordgo.Presence, err error) {
+	err = discordgo.ErrStateNotFound
+	for _, guildID := range d.guildIDs {
+		if presence, err = d.Session.State.Presence(guildID, userID); err == nil {
+			return presence, nil
+		}
+	}
+	return nil, err
+}
+
+// memberRoles returns a user's roles in all of the bridged guilds
+func (d *discordBot) memberRoles(userID string) []string {
+	var roles []string
+	for _, guildID := range d.guildIDs {
+		if member, err := d.Session.State.Member(guildID, userID); err == nil {
+			roles = append(roles, member.Roles...)
+		}
+	}
+	return roles
+}
+
+// members returns the members of all of the bridged guilds.
+// Someone in several guilds is included once for each.
+func (d *discordBot) members() []*discordgo.Member {
+	var members []*discordgo.Member
+	for _, guildID := range d.guildIDs {
+		guild, err := d.Session.State.Guild(guildID)
+		if err != nil {
+			continue
+		}
+
+		d.Session.State.RLock()
+		members = append(members, guild.Members...)
+		d.Session.State.RUnlock()
+	}
+	return members
+}
+
+// transmitterFor returns the webhook transmitter for a channel's guild
+func (d *discordBot) transmitterFor(channelID string) *transmitter.Transmitter {
+	if t, ok := d.transmitters[d.channelGuild(channelID)]; ok {
+		return t
+	}
+	return d.transmitters[d.guildID]
+}
+
+// isWebhook returns whether an ID belongs to one of our webhooks
+func (d *discordBot) isWebhook(id string) bool {
+	for _, t := range d.transmitters {
+		if t.HasWebhook(id) {
+			return true
+		}
+	}
+	return false
+}
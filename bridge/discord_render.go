@@ -0,0 +1,199 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// Limits so that a single bot embed can't flood an IRC channel
+const (
+	embedMaxDescriptionLength = 300
+	embedMaxDescriptionLines  = 3
+	embedMaxFields            = 5
+	embedMaxFieldLength       = 100
+)
+
+// renderMessageExtras returns IRC lines for the parts of a Discord message
+// that are not part of its content: stickers, rich embeds and polls.
+func renderMessageExtras(m *discordgo.Message) (lines []string) {
+	for _, sticker := range m.StickerItems {
+		lines = append(lines, renderSticker(sticker))
+	}
+
+	for _, embed := range m.Embeds {
+		lines = append(lines, renderEmbed(embed)...)
+	}
+
+	if m.Poll != nil {
+		lines = append(lines, renderPoll(m.Poll)...)
+	}
+
+	return lines
+}
+
+// renderSticker renders a sticker as `[sticker: name] <url>`
+func renderSticker(s *discordgo.StickerItem) string {
+	ext := ".png"
+	switch s.FormatType {
+	case discordgo.StickerFormatTypeLottie:
+		// Lottie stickers are JSON animations, there is no image to link to
+		return fmt.Sprintf("[sticker: %s]", s.Name)
+	case discordgo.StickerFormatTypeGIF:
+		ext = ".gif"
+	}
+
+	return fmt.Sprintf("[sticker: %s] %sstickers/%s%s", s.Name, discordgo.EndpointCDN, s.ID, ext)
+}
+
+// renderEmbed renders a rich embed as a title line, followed by
+// its (truncated) description, fields, and URL.
+//
+// Other embed types are link previews generated by Discord for URLs
+// already present in the message content, so they are not rendered.
+func renderEmbed(e *discordgo.MessageEmbed) (lines []string) {
+	if e.Type != "" && e.Type != discordgo.EmbedTypeRich {
+		return nil
+	}
+
+	title := e.Title
+	if title == "" && e.Author != nil {
+		title = e.Author.Name
+	}
+	if title != "" {
+		lines = append(lines, "[embed] "+oneLine(title))
+	}
+
+	if e.Description != "" {
+		description := strings.Split(TruncateString(embedMaxDescriptionLength, e.Description), "\n")
+		if len(description) > embedMaxDescriptionLines {
+			description = append(description[:embedMaxDescriptionLines], "…")
+		}
+		for _, line := range description {
+			if line = strings.TrimSpace(line); line != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	for i, field := range e.Fields {
+		if i == embedMaxFields {
+			lines = append(lines, fmt.Sprintf("(+%d more fields)", len(e.Fields)-embedMaxFields))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", oneLine(field.Name), TruncateString(embedMaxFieldLength, oneLine(field.Value))))
+	}
+
+	if e.URL != "" {
+		lines = append(lines, e.URL)
+	}
+
+	// Embeds without any text still deserve a mention
+	if len(lines) == 0 {
+		lines = append(lines, "[embed]")
+	}
+
+	return lines
+}
+
+// renderPoll renders a poll as its question, followed by numbered options
+func renderPoll(p *discordgo.Poll) []string {
+	lines := []string{"[poll] " + oneLine(renderPollMedia(&p.Question))}
+	for i, answer := range p.Answers {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+1, oneLine(renderPollMedia(answer.Media))))
+	}
+	return lines
+}
+
+func renderPollMedia(media *discordgo.PollMedia) string {
+	if media == nil {
+		return ""
+	}
+
+	text := media.Text
+	if media.Emoji != nil && media.Emoji.Name != "" {
+		emoji := media.Emoji.Name
+		if media.Emoji.ID != "" {
+			// Custom emoji
+			emoji = ":" + emoji + ":"
+		}
+		text = strings.TrimSpace(emoji + " " + text)
+	}
+	return text
+}
+
+// oneLine collapses newlines and repeated whitespace into single spaces
+func oneLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// attachmentMaxDescriptionLength limits how much alt text is shown for an attachment
+const attachmentMaxDescriptionLength = 100
+
+// renderAttachments renders attachments with renderAttachment. If limit is
+// positive, at most that many are rendered, followed by a line saying how
+// many more there are.
+//
+// descriptions is the alt text of each attachment, by attachment ID.
+func renderAttachments(attachments []*discordgo.MessageAttachment, descriptions map[string]string, limit int) (lines []string) {
+	for i, a := range attachments {
+		if limit > 0 && i == limit {
+			lines = append(lines, fmt.Sprintf("[+%d more attachments]", len(attachments)-limit))
+			break
+		}
+		lines = append(lines, renderAttachment(a, descriptions[a.ID]))
+	}
+	return lines
+}
+
+// renderAttachment renders an attachment as `[image 1.2 MB: description] <url>`,
+// falling back to the filename if there is no description
+func renderAttachment(a *discordgo.MessageAttachment, description string) string {
+	filename := a.Filename
+	spoiler := strings.HasPrefix(filename, "SPOILER_")
+	if spoiler {
+		filename = strings.TrimPrefix(filename, "SPOILER_")
+	}
+
+	kind := "file"
+	if t := strings.SplitN(a.ContentType, "/", 2)[0]; t == "image" || t == "video" || t == "audio" {
+		kind = t
+	}
+
+	label := []string{kind}
+	if spoiler {
+		label = []string{"spoiler", kind}
+	}
+	if a.Size > 0 {
+		label = append(label, formatSize(a.Size))
+	}
+
+	name := TruncateString(attachmentMaxDescriptionLength, oneLine(description))
+	if name == "" {
+		name = filename
+	}
+	if name != "" {
+		label[len(label)-1] += ":"
+		label = append(label, name)
+	}
+
+	return fmt.Sprintf("[%s] %s", strings.Join(label, " "), a.URL)
+}
+
+// formatSize formats a number of bytes like Discord does, e.g. "1.2 MB"
+func formatSize(bytes int) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	size := float64(bytes) / unit
+	prefixes := "KMGT"
+	i := 0
+	for size >= unit && i < len(prefixes)-1 {
+		size /= unit
+		i++
+	}
+	return fmt.Sprintf("%.1f %cB", size, prefixes[i])
+}
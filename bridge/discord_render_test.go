@@ -0,0 +1,100 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderSticker(t *testing.T) {
+	cases := []struct {
+		Message  string
+		Input    *discordgo.StickerItem
+		Expected string
+	}{
+		{"png", &discordgo.StickerItem{ID: "1", Name: "wave", FormatType: discordgo.StickerFormatTypePNG}, "[sticker: wave] https://cdn.discordapp.com/stickers/1.png"},
+		{"gif", &discordgo.StickerItem{ID: "2", Name: "dance", FormatType: discordgo.StickerFormatTypeGIF}, "[sticker: dance] https://cdn.discordapp.com/stickers/2.gif"},
+		{"lottie", &discordgo.StickerItem{ID: "3", Name: "blob", FormatType: discordgo.StickerFormatTypeLottie}, "[sticker: blob]"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Message, func(t *testing.T) {
+			assert.Equal(t, c.Expected, renderSticker(c.Input))
+		})
+	}
+}
+
+func TestRenderEmbed(t *testing.T) {
+	embed := &discordgo.MessageEmbed{
+		Type:        discordgo.EmbedTypeRich,
+		Title:       "New issue",
+		Description: "line one\nline two\n\nline three\nline four",
+		URL:         "https://example.com/1",
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Repo", Value: "qaisjp/go-discord-irc"},
+			{Name: "Labels", Value: "bug\nhelp wanted"},
+		},
+	}
+
+	assert.Equal(t, []string{
+		"[embed] New issue",
+		"line one",
+		"line two",
+		"…",
+		"Repo: qaisjp/go-discord-irc",
+		"Labels: bug help wanted",
+		"https://example.com/1",
+	}, renderEmbed(embed))
+
+	// Link previews are not rendered
+	assert.Empty(t, renderEmbed(&discordgo.MessageEmbed{Type: discordgo.EmbedTypeLink, Title: "Example"}))
+}
+
+func TestRenderPoll(t *testing.T) {
+	poll := &discordgo.Poll{
+		Question: discordgo.PollMedia{Text: "Lunch?"},
+		Answers: []discordgo.PollAnswer{
+			{Media: &discordgo.PollMedia{Text: "Pizza", Emoji: &discordgo.ComponentEmoji{Name: "🍕"}}},
+			{Media: &discordgo.PollMedia{Text: "Sushi"}},
+		},
+	}
+
+	assert.Equal(t, []string{"[poll] Lunch?", "1. 🍕 Pizza", "2. Sushi"}, renderPoll(poll))
+}
+
+func TestRenderAttachment(t *testing.T) {
+	cases := []struct {
+		Message     string
+		Input       *discordgo.MessageAttachment
+		Description string
+		Expected    string
+	}{
+		{"image with description", &discordgo.MessageAttachment{URL: "u", Filename: "cat.png", ContentType: "image/png", Size: 1258291}, "a cat\nsleeping", "[image 1.2 MB: a cat sleeping] u"},
+		{"filename fallback", &discordgo.MessageAttachment{URL: "u", Filename: "notes.txt", ContentType: "text/plain; charset=utf-8", Size: 512}, "", "[file 512 B: notes.txt] u"},
+		{"spoiler", &discordgo.MessageAttachment{URL: "u", Filename: "SPOILER_ending.mp4", ContentType: "video/mp4", Size: 52428800}, "", "[spoiler video 50.0 MB: ending.mp4] u"},
+		{"no size or content type", &discordgo.MessageAttachment{URL: "u", Filename: "x"}, "", "[file: x] u"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Message, func(t *testing.T) {
+			assert.Equal(t, c.Expected, renderAttachment(c.Input, c.Description))
+		})
+	}
+}
+
+func TestRenderAttachmentsLimit(t *testing.T) {
+	attachments := []*discordgo.MessageAttachment{
+		{ID: "1", URL: "a", Filename: "a.png", ContentType: "image/png"},
+		{ID: "2", URL: "b", Filename: "b.png", ContentType: "image/png"},
+		{ID: "3", URL: "c", Filename: "c.png", ContentType: "image/png"},
+	}
+	descriptions := map[string]string{"2": "bee"}
+
+	assert.Equal(t, []string{
+		"[image: a.png] a",
+		"[image: bee] b",
+		"[+1 more attachments]",
+	}, renderAttachments(attachments, descriptions, 2))
+	assert.Len(t, renderAttachments(attachments, nil, 0), 3)
+}
@@ -0,0 +1,73 @@
+package bridge
+
+import (
+	"strings"
+)
+
+// Edits changing more words than this are relayed in full
+const editDiffMaxWords = 5
+
+// Number of unchanged words shown either side of an edit
+const editDiffContext = 3
+
+// editDiff produces a compact word-level diff between two versions of a
+// message, like "… the quick ~~brown~~ → red fox …".
+//
+// Returns false if the change is too large for a diff to be useful,
+// in which case the new text should be relayed in full.
+func editDiff(oldText, newText string) (string, bool) {
+	oldWords := strings.Fields(oldText)
+	newWords := strings.Fields(newText)
+
+	// Find the common prefix and suffix, the rest has changed
+	prefix := 0
+	for prefix < len(oldWords) && prefix < len(newWords) && oldWords[prefix] == newWords[prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(oldWords)-prefix && suffix < len(newWords)-prefix &&
+		oldWords[len(oldWords)-1-suffix] == newWords[len(newWords)-1-suffix] {
+		suffix++
+	}
+
+	removed := oldWords[prefix : len(oldWords)-suffix]
+	added := newWords[prefix : len(newWords)-suffix]
+
+	// Only whitespace changed
+	if len(removed) == 0 && len(added) == 0 {
+		return "", false
+	}
+
+	changed := len(removed)
+	if len(added) > changed {
+		changed = len(added)
+	}
+	if changed > editDiffMaxWords || changed*2 > len(newWords) {
+		return "", false
+	}
+
+	var parts []string
+
+	before := newWords[:prefix]
+	if len(before) > editDiffContext {
+		parts = append(parts, "…")
+		before = before[len(before)-editDiffContext:]
+	}
+	parts = append(parts, before...)
+
+	if len(removed) > 0 {
+		parts = append(parts, "~~"+strings.Join(removed, " ")+"~~")
+	}
+	if len(added) > 0 {
+		parts = append(parts, "→", strings.Join(added, " "))
+	}
+
+	after := newWords[len(newWords)-suffix:]
+	if len(after) > editDiffContext {
+		after = append(after[:editDiffContext:editDiffContext], "…")
+	}
+	parts = append(parts, after...)
+
+	return strings.Join(parts, " "), true
+}
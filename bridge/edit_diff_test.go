@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEditDiff(t *testing.T) {
+	cases := []struct {
+		Message  string
+		Old      string
+		New      string
+		Expected string
+		OK       bool
+	}{
+		{"replace", "the quick brown fox jumps", "the quick red fox jumps", "the quick ~~brown~~ → red fox jumps", true},
+		{"insert", "the fox jumps over", "the brown fox jumps over", "the → brown fox jumps over", true},
+		{"delete", "the brown fox jumps over", "the fox jumps over", "the ~~brown~~ fox jumps over", true},
+		{"context", "one two three four five six seven eight nine ten", "one two three four five 6 seven eight nine ten", "… three four five ~~six~~ → 6 seven eight nine …", true},
+		{"newlines", "hello\nworld and everyone", "hello\nworld and everybody", "hello world and ~~everyone~~ → everybody", true},
+		{"whitespace", "hello  world", "hello world", "", false},
+		{"rewrite", "hello world", "goodbye everyone", "", false},
+		{"large", "a b c d e f g h i j k l m n", "a 1 2 3 4 5 6 h i j k l m n", "", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Message, func(t *testing.T) {
+			diff, ok := editDiff(c.Old, c.New)
+			assert.Equal(t, c.OK, ok)
+			assert.Equal(t, c.Expected, diff)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package bridge
+
+import (
+	"sort"
+	"strings"
+)
+
+// ircLineLength is how much text is put in each reply line, leaving
+// room for the rest of the IRC message in the 512 byte limit
+const ircLineLength = 400
+
+// A command is something IRC users can ask the bridge to do
+type command struct {
+	Name  string
+	Usage string // arguments, shown in help
+	Help  string
+
+	Run func(req *commandRequest, args []string)
+}
+
+// A commandRequest is a command sent by an IRC user
+type commandRequest struct {
+	Nick    string // who sent the command
+	Channel string // the channel it was sent in, blank for private messages
+
+	// The puppet a private message was sent to, nil if it was sent to the listener
+	Puppet *ircConnection
+
+	reply func(text string)
+}
+
+// Reply answers a command
+func (r *commandRequest) Reply(text string) {
+	r.reply(text)
+}
+
+// ReplyList answers a command with a list of words, split over
+// as many lines as needed
+func (r *commandRequest) ReplyList(prefix string, words []string) {
+	for _, line := range wrapWords(prefix, words, ircLineLength) {
+		r.reply(line)
+	}
+}
+
+// A commandRouter runs commands by name
+type commandRouter struct {
+	commands map[string]*command
+}
+
+func newCommandRouter() *commandRouter {
+	return &commandRouter{commands: make(map[string]*command)}
+}
+
+// Register adds a command
+func (r *commandRouter) Register(cmd *command) {
+	r.commands[strings.ToLower(cmd.Name)] = cmd
+}
+
+// Lookup returns the command named by the first word of text, and its arguments
+func (r *commandRouter) Lookup(text string) (*command, []string, bool) {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return nil, nil, false
+	}
+
+	cmd, ok := r.commands[strings.ToLower(fields[0])]
+	return cmd, fields[1:], ok
+}
+
+// Run runs the command in text, returning false if there is no such command
+func (r *commandRouter) Run(req *commandRequest, text string) bool {
+	cmd, args, ok := r.Lookup(text)
+	if !ok {
+		return false
+	}
+
+	cmd.Run(req, args)
+	return true
+}
+
+// RunExact runs the command named by text, returning false unless text is
+// just a command's name. Used where most messages aren't meant as commands.
+func (r *commandRouter) RunExact(req *commandRequest, text string) bool {
+	cmd, ok := r.commands[strings.ToLower(strings.TrimSpace(text))]
+	if !ok {
+		return false
+	}
+
+	cmd.Run(req, nil)
+	return true
+}
+
+// Help returns a line of help for each command, sorted by name
+func (r *commandRouter) Help(prefix string) []string {
+	var lines []string
+	for _, cmd := range r.commands {
+		line := prefix + cmd.Name
+		if cmd.Usage != "" {
+			line += " " + cmd.Usage
+		}
+		lines = append(lines, line+" - "+cmd.Help)
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// wrapWords joins words into lines no longer than length, where possible.
+// Each line starts with prefix.
+func wrapWords(prefix string, words []string, length int) []string {
+	var lines []string
+	var line []string
+	lineLength := len(prefix)
+	for _, word := range words {
+		if len(line) > 0 && lineLength+1+len(word) > length {
+			lines = append(lines, strings.TrimSpace(prefix+" "+strings.Join(line, " ")))
+			line = nil
+			lineLength = len(prefix)
+		}
+		line = append(line, word)
+		lineLength += 1 + len(word)
+	}
+	return append(lines, strings.TrimSpace(prefix+" "+strings.Join(line, " ")))
+}
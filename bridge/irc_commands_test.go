@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandRouter(t *testing.T) {
+	var ran []string
+	r := newCommandRouter()
+	r.Register(&command{Name: "who", Usage: "[#channel]", Help: "list users", Run: func(req *commandRequest, args []string) {
+		ran = append(ran, args...)
+	}})
+	r.Register(&command{Name: "help", Help: "show help", Run: func(req *commandRequest, args []string) {}})
+
+	req := &commandRequest{Nick: "alice"}
+	assert.True(t, r.Run(req, "WHO  #chan  extra"))
+	assert.Equal(t, []string{"#chan", "extra"}, ran)
+	assert.False(t, r.Run(req, "nope"))
+	assert.False(t, r.Run(req, "  "))
+
+	ran = nil
+	assert.True(t, r.RunExact(req, " Who "))
+	assert.Empty(t, ran)
+	assert.False(t, r.RunExact(req, "who are you?"))
+	assert.False(t, r.RunExact(req, "help me"))
+
+	assert.Equal(t, []string{
+		"!discord help - show help",
+		"!discord who [#channel] - list users",
+	}, r.Help("!discord "))
+}
+
+func TestWrapWords(t *testing.T) {
+	assert.Equal(t, []string{"Online: aa bb", "Online: cc"}, wrapWords("Online:", []string{"aa", "bb", "cc"}, 14))
+	assert.Equal(t, []string{"Online:"}, wrapWords("Online:", nil, 14))
+	assert.Equal(t, []string{"a b"}, wrapWords("", []string{"a", "b"}, 10))
+	assert.Equal(t, []string{"x verylongword"}, wrapWords("x", []string{"verylongword"}, 5))
+}
+
+func TestFormatAge(t *testing.T) {
+	day := 24 * time.Hour
+	assert.Equal(t, "0 days", formatAge(time.Hour))
+	assert.Equal(t, "1 day", formatAge(day))
+	assert.Equal(t, "2 months", formatAge(65*day))
+	assert.Equal(t, "3 years", formatAge(3*366*day))
+}
@@ -29,6 +29,18 @@ type ircConnection struct {
 	// Tell users this feature is in beta
 	pmNoticed        bool
 	pmNoticedSenders map[string]struct{}
+
+	// IRC users who don't want their private messages sent to Discord
+	mutedSenders map[string]struct{}
+
+	// The puppet's away message, blank if not away
+	away string
+
+	// The nick claimed by the Discord user when the nick was generated
+	claimedNick string
+
+	// Whether to rejoin channels after being kicked
+	rejoins *rejoinTracker
 }
 
 func (i *ircConnection) GetNick() string {
@@ -61,6 +73,11 @@ func (i *ircConnection) OnWelcome(e *irc.Event) {
 
 	go func(i *ircConnection) {
 		for m := range i.messages {
+			// Remember lines once they are written, so echoes match up
+			if m.relayed != nil {
+				i.manager.relayed.Sent(*m.relayed, m.Message)
+			}
+
 			msg := m.Message
 			if m.IsAction {
 				msg = fmt.Sprintf("\001ACTION %s\001", msg)
@@ -71,7 +88,13 @@ func (i *ircConnection) OnWelcome(e *irc.Event) {
 }
 
 func (i *ircConnection) JoinChannels() {
-	i.SendRaw(i.manager.bridge.GetJoinCommand(i.manager.RequestChannels(i.discord.ID)))
+	channels := i.channels()
+	if len(channels) == 0 {
+		return
+	}
+
+	i.SendRaw(i.manager.bridge.GetJoinCommand(channels))
+	i.requestModes(channels)
 }
 
 func (i *ircConnection) UpdateDetails(discord DiscordUser) {
@@ -87,12 +110,15 @@ func (i *ircConnection) UpdateDetails(discord DiscordUser) {
 		return
 	}
 
+	claimedNick, _ := i.manager.nickClaims.Get(discord.ID)
+
 	// if their details haven't changed, don't do anything
-	if (i.discord.Nick == discord.Nick) && (i.discord.Discriminator == discord.Discriminator) {
+	if (i.discord.Nick == discord.Nick) && (i.discord.Discriminator == discord.Discriminator) && (i.claimedNick == claimedNick) {
 		return
 	}
 
 	i.discord = discord
+	i.claimedNick = claimedNick
 	delete(i.manager.puppetNicks, i.nick)
 	i.nick = i.manager.generateNickname(i.discord)
 	i.manager.puppetNicks[i.nick] = i
@@ -132,22 +158,55 @@ func (i *ircConnection) introducePM(nick string) {
 	}
 }
 
+// onEcho records our own messages being echoed back by the server.
+// Returns false if the event was not an echo.
+func (i *ircConnection) onEcho(e *irc.Event) bool {
+	if e.Nick != e.Connection.GetNick() {
+		return false
+	}
+
+	i.manager.relayed.Echoed(i.discord.ID, e.Arguments[0], e.Message(), e.Tags["msgid"])
+	return true
+}
+
+// OnAction only handles echoes, actions sent to puppets aren't relayed
+func (i *ircConnection) OnAction(e *irc.Event) {
+	i.onEcho(e)
+}
+
 func (i *ircConnection) OnPrivateMessage(e *irc.Event) {
+	if i.onEcho(e) {
+		return
+	}
+
 	// Ignored hostmasks
-	if i.manager.isIgnoredHostmask(e.Source) {
+	if i.manager.isIgnoredHostmask(e.Arguments[0], e.Source) {
 		return
 	}
 
 	// Alert private messages
 	if string(e.Arguments[0][0]) != "#" {
-		if e.Message() == "help" {
-			i.Privmsg(e.Nick, "Commands: help, who")
-		} else if e.Message() == "who" {
-			i.Privmsg(e.Nick, fmt.Sprintf("I am: %s#%s with ID %s", i.discord.Nick, i.discord.Discriminator, i.discord.ID))
+		req := &commandRequest{
+			Nick:   e.Nick,
+			Puppet: i,
+			reply: func(text string) {
+				i.Privmsg(e.Nick, text)
+			},
+		}
+		if i.manager.puppetCommands.RunExact(req, e.Message()) {
+			return
+		}
+
+		if _, ok := i.mutedSenders[strings.ToLower(e.Nick)]; ok {
+			return
 		}
 
 		d := i.manager.bridge.discord
 
+		if i.manager.bridge.Config.PMChannel != "" && d.sendPMThread(i.discord.ID, e.Nick, e.Message()) {
+			return
+		}
+
 		i.introducePM(e.Nick)
 
 		msg := fmt.Sprintf(
@@ -172,6 +231,7 @@ func (i *ircConnection) SendRaw(message string) {
 }
 
 func (i *ircConnection) SetAway(status string) {
+	i.away = status
 	i.SendRaw(fmt.Sprintf("AWAY :%s", status))
 }
 
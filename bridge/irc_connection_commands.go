@@ -0,0 +1,164 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// newPuppetCommands returns the commands IRC users can send to puppets in
+// private messages, on their own. Anything else, like "who are you?", is
+// forwarded to the Discord user.
+func newPuppetCommands() *commandRouter {
+	r := newCommandRouter()
+
+	r.Register(&command{
+		Name: "help",
+		Help: "show this help",
+		Run: func(req *commandRequest, args []string) {
+			for _, line := range r.Help("") {
+				req.Reply(line)
+			}
+			req.Reply(fmt.Sprintf("Anything else is sent to %s on Discord", req.Puppet.discord.Username))
+		},
+	})
+	r.Register(&command{
+		Name: "who",
+		Help: "show who this is on Discord",
+		Run:  puppetWho,
+	})
+	r.Register(&command{
+		Name: "status",
+		Help: "show whether they're online on Discord, and what they're doing",
+		Run:  puppetStatus,
+	})
+	r.Register(&command{
+		Name: "profile",
+		Help: "show their Discord display name, roles and account age",
+		Run:  puppetProfile,
+	})
+	r.Register(&command{
+		Name: "mute",
+		Help: "stop sending your private messages to them on Discord",
+		Run: func(req *commandRequest, args []string) {
+			req.Puppet.mutedSenders[strings.ToLower(req.Nick)] = struct{}{}
+			req.Reply("Your messages won't be sent to Discord any more. Send \"unmute\" to undo this.")
+		},
+	})
+	r.Register(&command{
+		Name: "unmute",
+		Help: "send your private messages to them on Discord again",
+		Run: func(req *commandRequest, args []string) {
+			delete(req.Puppet.mutedSenders, strings.ToLower(req.Nick))
+			req.Reply("Your messages will be sent to Discord again.")
+		},
+	})
+
+	return r
+}
+
+func puppetWho(req *commandRequest, args []string) {
+	user := req.Puppet.discord
+	req.Reply(fmt.Sprintf("I am: %s#%s with ID %s", user.Nick, user.Discriminator, user.ID))
+}
+
+func puppetStatus(req *commandRequest, args []string) {
+	i := req.Puppet
+	d := i.manager.bridge.discord
+
+	status := "offline"
+	var activities []string
+	if presence, err := d.presence(i.discord.ID); err == nil {
+		if presence.Status != "" && presence.Status != discordgo.StatusInvisible {
+			status = string(presence.Status)
+		}
+		for _, a := range presence.Activities {
+			if a.Type == discordgo.ActivityTypeCustom {
+				if a.State != "" {
+					activities = append(activities, fmt.Sprintf("%q", a.State))
+				}
+				continue
+			}
+			activities = append(activities, activityText(a))
+		}
+	}
+
+	text := fmt.Sprintf("%s is %s on Discord", i.discord.Nick, status)
+	if len(activities) > 0 {
+		text += ", " + strings.Join(activities, ", ")
+	}
+	req.Reply(text)
+
+	if i.away != "" {
+		req.Reply("Away on IRC: " + i.away)
+	}
+}
+
+// activityText describes an activity, like "playing Minecraft"
+func activityText(a *discordgo.Activity) string {
+	switch a.Type {
+	case discordgo.ActivityTypeStreaming:
+		return "streaming " + a.Name
+	case discordgo.ActivityTypeListening:
+		return "listening to " + a.Name
+	case discordgo.ActivityTypeWatching:
+		return "watching " + a.Name
+	case discordgo.ActivityTypeCompeting:
+		return "competing in " + a.Name
+	default:
+		return "playing " + a.Name
+	}
+}
+
+func puppetProfile(req *commandRequest, args []string) {
+	i := req.Puppet
+	d := i.manager.bridge.discord
+
+	member, guildID, err := d.member(i.discord.ID)
+	if err != nil {
+		req.Reply("Could not find their Discord profile")
+		return
+	}
+
+	req.Reply(fmt.Sprintf("Display name: %s (username %s)", GetMemberNick(member), member.User.Username))
+
+	var roles []string
+	for _, id := range member.Roles {
+		if role, err := d.Session.State.Role(guildID, id); err == nil {
+			roles = append(roles, role.Name)
+		}
+	}
+	if len(roles) > 0 {
+		req.ReplyList("Roles:", roles)
+	}
+
+	if created, err := discordgo.SnowflakeTimestamp(member.User.ID); err == nil {
+		text := fmt.Sprintf("Account created %s (%s ago)", created.Format("2 Jan 2006"), formatAge(time.Since(created)))
+		if !member.JoinedAt.IsZero() {
+			text += fmt.Sprintf(", joined the server %s", member.JoinedAt.Format("2 Jan 2006"))
+		}
+		req.Reply(text)
+	}
+}
+
+// formatAge formats a long duration roughly, like "2 years" or "3 days"
+func formatAge(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	switch {
+	case days >= 365:
+		return plural(days/365, "year")
+	case days >= 30:
+		return plural(days/30, "month")
+	default:
+		return plural(days, "day")
+	}
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
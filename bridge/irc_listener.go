@@ -2,7 +2,9 @@ package bridge
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
+	"sync"
 
 	ircf "github.com/qaisjp/go-discord-irc/irc/format"
 	irc "github.com/qaisjp/go-ircevent"
@@ -14,12 +16,32 @@ type ircListener struct {
 	bridge *Bridge
 
 	listenerCallbackIDs map[string]int
+
+	whois    *whoisTracker
+	commands *commandRouter
+
+	topicsMu sync.Mutex
+	topics   map[string]string // by lowercase channel
+
+	nickLength int32 // the server's NICKLEN, set atomically
+
+	ops    *chanOps    // channels we can set the topic in
+	grants *modeGrants // role_modes to give puppets when they join
 }
 
 func newIRCListener(dib *Bridge, webIRCPass string) *ircListener {
 	irccon := irc.IRC(dib.Config.IRCListenerName, "discord")
-	listener := &ircListener{irccon, dib, make(map[string]int)}
+	listener := &ircListener{
+		Connection:          irccon,
+		bridge:              dib,
+		listenerCallbackIDs: make(map[string]int),
+		whois:               newWhoisTracker(),
+		topics:              make(map[string]string),
+		ops:                 newChanOps(),
+		grants:              newModeGrants(),
+	}
 
+	irccon.RequestCaps = ircCapabilities
 	dib.SetupIRCConnection(irccon, "discord.", "fd75:f5f5:226f::")
 	listener.SetDebugMode(dib.Config.Debug)
 
@@ -35,6 +57,32 @@ func newIRCListener(dib *Bridge, webIRCPass string) *ircListener {
 	irccon.AddCallback("NOTICE", listener.OnPrivateMessage)
 	irccon.AddCallback("CTCP_ACTION", listener.OnPrivateMessage)
 
+	// Topics, for the /irc topic command and topic_sync
+	irccon.AddCallback("332", listener.onTopicReply)
+	irccon.AddCallback("TOPIC", listener.onTopic)
+
+	// Whether we are an operator, for setting topics from Discord
+	irccon.AddCallback("353", listener.onNamesReply)
+	irccon.AddCallback("MODE", listener.onMode)
+
+	// Give puppets their role_modes, for role_modes_via "mode"
+	irccon.AddCallback("JOIN", listener.onPuppetJoin)
+	irccon.AddCallback("QUIT", listener.onPuppetLeave)
+	irccon.AddCallback("NICK", listener.onPuppetLeave)
+	irccon.AddCallback("PART", listener.onPuppetPart)
+	irccon.AddCallback("KICK", listener.onPuppetPart)
+
+	// Keep user_lists up to date. The ST events are sent after nick tracking.
+	for _, code := range []string{"366", "STJOIN", "STPART", "STQUIT", "STNICK", "KICK", "MODE"} {
+		irccon.AddCallback(code, listener.onUserListEvent)
+	}
+
+	// RPL_ISUPPORT, for the server's NICKLEN
+	irccon.AddCallback("005", listener.onISupport)
+
+	listener.whois.addCallbacks(irccon)
+	listener.setupCommands()
+
 	irccon.AddCallback("900", func(e *irc.Event) {
 		// Try to rejoni channels after authenticated with NickServ
 		listener.JoinChannels()
@@ -50,6 +98,46 @@ func newIRCListener(dib *Bridge, webIRCPass string) *ircListener {
 	return listener
 }
 
+// RPL_TOPIC: <client> <channel> :<topic>
+func (i *ircListener) onTopicReply(e *irc.Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+	i.setTopic(e.Arguments[1], e.Arguments[2])
+	i.syncTopicToDiscord(e.Arguments[1], e.Arguments[2])
+}
+
+func (i *ircListener) onTopic(e *irc.Event) {
+	if len(e.Arguments) < 1 {
+		return
+	}
+	i.setTopic(e.Arguments[0], e.Message())
+
+	// Topics we set came from Discord, so Discord already has them
+	if e.Nick != i.GetNick() {
+		i.syncTopicToDiscord(e.Arguments[0], e.Message())
+	}
+}
+
+func (i *ircListener) setTopic(channel, topic string) {
+	i.topicsMu.Lock()
+	defer i.topicsMu.Unlock()
+	i.topics[strings.ToLower(channel)] = topic
+}
+
+// ChannelTopic returns the topic of a channel the listener is in
+func (i *ircListener) ChannelTopic(channel string) (string, bool) {
+	i.topicsMu.Lock()
+	defer i.topicsMu.Unlock()
+	topic, ok := i.topics[strings.ToLower(channel)]
+	return topic, ok
+}
+
+// LookupWhois sends a WHOIS for a nick, and waits for the reply
+func (i *ircListener) LookupWhois(nick string) (whoisReply, error) {
+	return i.whois.Lookup(nick, i.Whois)
+}
+
 func (i *ircListener) nickTrackNick(event *irc.Event) {
 	oldNick := event.Nick
 	newNick := event.Message()
@@ -60,9 +148,8 @@ func (i *ircListener) nickTrackNick(event *irc.Event) {
 }
 
 func (i *ircListener) OnNickRelayToDiscord(event *irc.Event) {
-	// ignored hostmasks, or we're a puppet? no relay
-	if i.bridge.ircManager.isIgnoredHostmask(event.Source) ||
-		i.isPuppetNick(event.Nick) ||
+	// we're a puppet? no relay
+	if i.isPuppetNick(event.Nick) ||
 		i.isPuppetNick(event.Message()) {
 		return
 	}
@@ -75,8 +162,10 @@ func (i *ircListener) OnNickRelayToDiscord(event *irc.Event) {
 		Message:  fmt.Sprintf("_%s changed their nick to %s_", oldNick, newNick),
 	}
 
-	for _, m := range i.bridge.mappings {
-		channel := m.IRCChannel
+	for _, channel := range i.bridge.ircChannels() {
+		if !i.bridge.showJoinQuit(channel) || i.bridge.ircManager.isIgnoredHostmask(channel, event.Source) {
+			continue
+		}
 		if channelObj, ok := i.Connection.GetChannel(channel); ok {
 			if _, ok := channelObj.GetUser(newNick); ok {
 				msg.IRCChannel = channel
@@ -104,8 +193,8 @@ func (i *ircListener) OnJoinQuitSettingChange() {
 	}
 
 	// we're either going to track quits, or track and relay said, so swap out the callback
-	// based on which is in effect.
-	if i.bridge.Config.ShowJoinQuit {
+	// based on which is in effect. Channels that don't show them are skipped when relaying.
+	if i.bridge.anyShowJoinQuit() {
 		i.listenerCallbackIDs["STNICK"] = i.AddCallback("STNICK", i.OnNickRelayToDiscord)
 
 		// KICK is not state tracked!
@@ -131,11 +220,6 @@ func (i *ircListener) OnJoinQuitCallback(event *irc.Event) {
 		return
 	}
 
-	// Ignored hostmasks
-	if i.bridge.ircManager.isIgnoredHostmask(event.Source) {
-		return
-	}
-
 	who := event.Nick
 	message := event.Nick
 	id := " (" + event.User + "@" + event.Host + ") "
@@ -169,8 +253,10 @@ func (i *ircListener) OnJoinQuitCallback(event *irc.Event) {
 
 	if event.Code == "STQUIT" {
 		// Notify channels that the user is in
-		for _, m := range i.bridge.mappings {
-			channel := m.IRCChannel
+		for _, channel := range i.bridge.ircChannels() {
+			if !i.bridge.showJoinQuit(channel) || i.bridge.ircManager.isIgnoredHostmask(channel, event.Source) {
+				continue
+			}
 			channelObj, ok := i.Connection.GetChannel(channel)
 			if !ok {
 				log.WithField("channel", channel).WithField("who", who).Warnln("Trying to process QUIT. Channel not found in irc listener cache.")
@@ -182,8 +268,8 @@ func (i *ircListener) OnJoinQuitCallback(event *irc.Event) {
 			msg.IRCChannel = channel
 			i.bridge.discordMessagesChan <- msg
 		}
-	} else {
-		msg.IRCChannel = event.Arguments[0]
+	} else if channel := event.Arguments[0]; i.bridge.showJoinQuit(channel) && !i.bridge.ircManager.isIgnoredHostmask(channel, event.Source) {
+		msg.IRCChannel = channel
 		i.bridge.discordMessagesChan <- msg
 	}
 }
@@ -234,40 +320,132 @@ func (i *ircListener) isPuppetNick(nick string) bool {
 	return false
 }
 
+// HasCapability returns whether the server acknowledged an IRCv3 capability
+func (i *ircListener) HasCapability(capability string) bool {
+	for _, c := range i.AcknowledgedCaps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
 func (i *ircListener) OnPrivateMessage(e *irc.Event) {
-	// Ignore private messages
+	// Our own messages echoed back by the server
+	if e.Nick == i.GetNick() && e.Code != "NOTICE" {
+		i.bridge.ircManager.relayed.Echoed("", e.Arguments[0], e.Message(), e.Tags["msgid"])
+		return
+	}
+
+	// Commands for the bridge, in channels or private messages
+	if !i.isPuppetNick(e.Nick) && !i.bridge.ircManager.isIgnoredHostmask("", e.Source) && i.runCommand(e) {
+		return
+	}
+
+	// Ignore other private messages
 	if string(e.Arguments[0][0]) != "#" {
-		// If you decide to extend this to respond to PMs, make sure
-		// you do not respond to NOTICEs, see issue #50.
 		return
 	}
 
 	if i.isPuppetNick(e.Nick) || // ignore msg's from our puppets
-		i.bridge.ircManager.isIgnoredHostmask(e.Source) || //ignored hostmasks
-		i.bridge.ircManager.isFilteredIRCMessage(e.Message()) { // filtered
+		i.bridge.ircManager.isIgnoredHostmask(e.Arguments[0], e.Source) || //ignored hostmasks
+		i.bridge.ircManager.isFilteredIRCMessage(e.Arguments[0], e.Message()) { // filtered
 		return
 	}
 
-	replacements := []string{}
-	for _, con := range i.bridge.ircManager.ircConnections {
-		replacements = append(replacements, con.nick, "<@!"+con.discord.ID+">")
+	// sed-style corrections edit the message on Discord instead
+	if sub, ok := parseSubstitution(e.Message()); ok && e.Code == "PRIVMSG" {
+		if i.bridge.CorrectMessage(e.Arguments[0], e.Nick, sub) {
+			return
+		}
 	}
 
-	msg := strings.NewReplacer(
-		replacements...,
-	).Replace(e.Message())
+	isAction := e.Code == "CTCP_ACTION"
+	text := e.Message()
 
-	if e.Code == "CTCP_ACTION" {
-		msg = "_" + msg + "_"
+	// Replies are worked out for each Discord channel, when it's sent
+	var replyNick string
+	if i.bridge.Config.IRCReplies && !isAction {
+		replyNick, _, _ = addressedTo(text)
 	}
 
-	msg = ircf.BlocksToMarkdown(ircf.Parse(msg))
+	msg := i.formatForDiscord(e.Arguments[0], text, isAction)
 
 	go func(e *irc.Event) {
 		i.bridge.discordMessagesChan <- IRCMessage{
 			IRCChannel: e.Arguments[0],
 			Username:   e.Nick,
 			Message:    msg,
+			IsAction:   isAction,
+			IRCText:    text,
+			ReplyNick:  replyNick,
+			IRCMsgID:   e.Tags["msgid"],
+			Prefix:     i.userPrefix(e.Arguments[0], e.Nick),
 		}
 	}(e)
 }
+
+// Matches messages addressed to someone, like "nick: hello" or "nick, hello"
+var addressedPattern = regexp.MustCompile(`^([^\s:,]+)[:,]\s+(.+)$`)
+
+// addressedTo returns who a message is addressed to, like "nick: hello",
+// and the rest of the message
+func addressedTo(text string) (nick, rest string, ok bool) {
+	match := addressedPattern.FindStringSubmatch(text)
+	if match == nil {
+		return "", "", false
+	}
+	return match[1], match[2], true
+}
+
+// replyHeader returns a header linking to the latest message on Discord of
+// someone who has recently spoken in the IRC channel of a mapping, for a
+// message addressed to them. Returns false if they haven't spoken.
+//
+// Webhooks can't send real replies, so the header stands in for one.
+func (b *Bridge) replyHeader(mapping Mapping, nick string) (string, bool) {
+	var who, channelID, messageID string
+	if msg, ok := b.ircManager.relayed.Latest(mapping.IRCChannel, nick); ok && msg.DiscordChannel != "" {
+		// Someone on Discord, whose message may be in another Discord channel
+		who, channelID, messageID = msg.Nick, msg.DiscordChannel, msg.DiscordID
+	} else if msg, ok := b.webhookHistory.Latest(mapping.DiscordChannel, mapping.IRCChannel, nick); ok {
+		// Someone else on IRC
+		who, channelID, messageID = nick, mapping.DiscordChannel, msg.ID
+	} else {
+		return "", false
+	}
+
+	// Their name is plain text, so that they aren't pinged
+	return fmt.Sprintf(
+		"-# ↪ replying to `%s`: https://discord.com/channels/%s/%s/%s",
+		who, b.discord.channelGuild(channelID), channelID, messageID,
+	), true
+}
+
+// formatForDiscord converts an IRC message in a channel to Discord markdown,
+// or strips its formatting if the channel's settings say so, and turns
+// puppet nicks into mentions of their Discord user
+func (i *ircListener) formatForDiscord(channel, text string, isAction bool) string {
+	replacements := []string{}
+	for _, con := range i.bridge.ircManager.ircConnections {
+		replacements = append(replacements, con.nick, "<@!"+con.discord.ID+">")
+	}
+
+	msg := strings.NewReplacer(
+		replacements...,
+	).Replace(text)
+
+	if i.bridge.settings(channel).Formatting == FormattingStrip {
+		msg = ircf.StripCodes(msg)
+		if isAction {
+			msg = "_" + msg + "_"
+		}
+		return msg
+	}
+
+	if isAction {
+		msg = "_" + msg + "_"
+	}
+
+	return ircf.BlocksToMarkdown(ircf.Parse(msg))
+}
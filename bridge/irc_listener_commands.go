@@ -0,0 +1,217 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// setupCommands registers the commands IRC users can send to the listener
+func (i *ircListener) setupCommands() {
+	i.commands = newCommandRouter()
+
+	i.commands.Register(&command{
+		Name: "help",
+		Help: "show this help",
+		Run:  i.commandHelp,
+	})
+	i.commands.Register(&command{
+		Name:  "who",
+		Usage: "[#channel]",
+		Help:  "list who is online on Discord in a bridged channel",
+		Run:   i.commandWho,
+	})
+	i.commands.Register(&command{
+		Name:  "whois",
+		Usage: "<nick>",
+		Help:  "show which Discord user a nick belongs to",
+		Run:   i.commandWhois,
+	})
+	i.commands.Register(&command{
+		Name:  "voice",
+		Usage: "[#channel]",
+		Help:  "list who is in the Discord voice channels announced in a channel",
+		Run:   i.commandVoice,
+	})
+}
+
+// runCommand runs a command sent to the listener, returning whether
+// the message was a command.
+//
+// In channels commands start with the command prefix, like "!discord who".
+// In private messages the prefix is optional.
+func (i *ircListener) runCommand(e *irc.Event) bool {
+	// Never answer NOTICEs, see issue #50
+	if e.Code != "PRIVMSG" {
+		return false
+	}
+
+	channel := e.Arguments[0]
+	isPM := !strings.HasPrefix(channel, "#")
+
+	text := strings.TrimSpace(e.Message())
+	prefix := i.bridge.Config.IRCCommandPrefix
+	if fields := strings.Fields(text); len(fields) > 0 && strings.EqualFold(fields[0], prefix) {
+		text = strings.TrimSpace(text[len(fields[0]):])
+	} else if strings.EqualFold(text, "!voice") && len(i.bridge.Config.VoiceChannels) > 0 {
+		// A shorthand for the voice command
+		text = "voice"
+	} else if !isPM {
+		return false
+	}
+
+	req := &commandRequest{Nick: e.Nick, Channel: channel}
+	replyTo := channel
+	if isPM {
+		req.Channel = ""
+		replyTo = e.Nick
+	}
+	req.reply = func(text string) {
+		i.Notice(replyTo, text)
+	}
+
+	if text == "" {
+		text = "help"
+	}
+
+	if !i.commands.Run(req, text) {
+		req.Reply(fmt.Sprintf("Unknown command, try \"%s help\"", prefix))
+	}
+	return true
+}
+
+// commandChannel returns the channel a command is about, which is the
+// first argument if there is one, or the channel it was sent in
+func commandChannel(req *commandRequest, args []string) (string, bool) {
+	if len(args) > 0 {
+		return args[0], true
+	}
+	if req.Channel != "" {
+		return req.Channel, true
+	}
+	req.Reply("Which channel? Try adding a channel, like #channel")
+	return "", false
+}
+
+func (i *ircListener) commandHelp(req *commandRequest, args []string) {
+	for _, line := range i.commands.Help(i.bridge.Config.IRCCommandPrefix + " ") {
+		req.Reply(line)
+	}
+}
+
+func (i *ircListener) commandWho(req *commandRequest, args []string) {
+	channel, ok := commandChannel(req, args)
+	if !ok {
+		return
+	}
+
+	mapping, ok := i.bridge.GetMappingByIRC(channel)
+	if !ok {
+		req.Reply(channel + " isn't bridged to Discord")
+		return
+	}
+
+	names := i.bridge.discord.onlineMembers(mapping.DiscordChannel)
+	if len(names) == 0 {
+		req.Reply(fmt.Sprintf("Nobody is online on Discord in %s", mapping.IRCChannel))
+		return
+	}
+
+	req.ReplyList(fmt.Sprintf("Online on Discord in %s (%d):", mapping.IRCChannel, len(names)), names)
+}
+
+func (i *ircListener) commandWhois(req *commandRequest, args []string) {
+	if len(args) != 1 {
+		req.Reply(fmt.Sprintf("Usage: %s whois <nick>", i.bridge.Config.IRCCommandPrefix))
+		return
+	}
+
+	nick := args[0]
+	if strings.EqualFold(nick, i.GetNick()) {
+		req.Reply(fmt.Sprintf("%s is the bridge itself", i.GetNick()))
+		return
+	}
+
+	// The puppets belong to the loop, so copy the one we want
+	var puppetNick string
+	var user DiscordUser
+	found := false
+	i.bridge.inLoop(func() {
+		for n, con := range i.bridge.ircManager.puppetNicks {
+			if strings.EqualFold(n, nick) {
+				puppetNick, user, found = n, con.discord, true
+				return
+			}
+		}
+	})
+
+	if !found {
+		req.Reply(fmt.Sprintf("%s isn't a Discord user", nick))
+		return
+	}
+
+	name := user.Username
+	if user.Discriminator != "" && user.Discriminator != "0" {
+		name += "#" + user.Discriminator
+	}
+
+	text := fmt.Sprintf("%s is Discord user %s (ID %s)", puppetNick, name, user.ID)
+	if user.Nick != "" && user.Nick != user.Username {
+		text = fmt.Sprintf("%s is Discord user %s, known as %s (ID %s)", puppetNick, name, user.Nick, user.ID)
+	}
+	req.Reply(text)
+}
+
+func (i *ircListener) commandVoice(req *commandRequest, args []string) {
+	channel, ok := commandChannel(req, args)
+	if !ok {
+		return
+	}
+
+	req.Reply(i.bridge.discord.voiceSummary(channel))
+}
+
+// onlineMembers returns the display names of the people who are
+// online and can see a Discord channel, sorted by name
+func (d *discordBot) onlineMembers(channelID string) []string {
+	guild, err := d.Session.State.Guild(d.channelGuild(channelID))
+	if err != nil {
+		log.WithError(err).Errorln("could not get guild to list online members")
+		return nil
+	}
+
+	// Permissions are checked after unlocking, as that locks the state too
+	var online []*discordgo.Member
+	d.Session.State.RLock()
+	status := make(map[string]discordgo.Status, len(guild.Presences))
+	for _, p := range guild.Presences {
+		status[p.User.ID] = p.Status
+	}
+	for _, m := range guild.Members {
+		if m.User == nil || m.User.Bot {
+			continue
+		}
+		if s := status[m.User.ID]; s != "" && s != discordgo.StatusOffline && s != discordgo.StatusInvisible {
+			online = append(online, m)
+		}
+	}
+	d.Session.State.RUnlock()
+
+	var names []string
+	for _, m := range online {
+		perms, err := d.Session.State.UserChannelPermissions(m.User.ID, channelID)
+		if err != nil || perms&discordgo.PermissionViewChannel == 0 {
+			continue
+		}
+		names = append(names, GetMemberNick(m))
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	return names
+}
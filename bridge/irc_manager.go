@@ -18,6 +18,18 @@ import (
 // DevMode is a hack
 var DevMode = false
 
+// IRCv3 capability used to remove messages deleted on Discord
+const redactionCapability = "draft/message-redaction"
+
+// ircCapabilities are requested by all of our IRC connections, if supported
+var ircCapabilities = []string{"echo-message", "message-tags", redactionCapability}
+
+// Deleting more messages than this at once is summarised, and redactions are paced
+const (
+	deletionBurst    = 3
+	deletionInterval = time.Second
+)
+
 // IRCManager should only be used from one thread.
 type IRCManager struct {
 	ircConnections map[string]*ircConnection
@@ -25,6 +37,19 @@ type IRCManager struct {
 
 	bridge *Bridge
 	varys  varys.Client
+
+	// Discord messages recently sent to IRC
+	relayed *relayHistory
+
+	// Commands IRC users can send to puppets
+	puppetCommands *commandRouter
+
+	// IRC nicks chosen by Discord users
+	nickClaims *nickClaims
+
+	// Redactions waiting to be sent, and when to send the next one
+	redactions  []redaction
+	redactTimer <-chan time.Time
 }
 
 // NewIRCManager creates a new IRCManager
@@ -34,6 +59,9 @@ func newIRCManager(bridge *Bridge) (*IRCManager, error) {
 		ircConnections: make(map[string]*ircConnection),
 		puppetNicks:    make(map[string]*ircConnection),
 		bridge:         bridge,
+		relayed:        newRelayHistory(),
+		puppetCommands: newPuppetCommands(),
+		nickClaims:     newNickClaims(bridge.store),
 	}
 
 	// Set up varys
@@ -64,6 +92,8 @@ func newIRCManager(bridge *Bridge) (*IRCManager, error) {
 			messages:         make(chan IRCMessage),
 			manager:          m,
 			pmNoticedSenders: make(map[string]struct{}),
+			mutedSenders:     make(map[string]struct{}),
+			rejoins:          newRejoinTracker(),
 		}
 	}
 
@@ -81,6 +111,7 @@ func (m *IRCManager) CloseConnection(i *ircConnection) {
 
 	delete(m.ircConnections, i.discord.ID)
 	delete(m.puppetNicks, i.nick)
+	m.bridge.ircListener.grants.Forget(i.nick)
 	close(i.messages)
 
 	if DevMode {
@@ -130,8 +161,10 @@ func (m *IRCManager) DisconnectUser(userID string) {
 
 var connectionsIgnored = 0
 
-func (m *IRCManager) ircIgnoredDiscord(user string) bool {
-	_, ret := m.bridge.Config.DiscordIgnores[user]
+// ircIgnoredDiscord returns whether a Discord user is ignored in an IRC
+// channel, or everywhere if channel is blank
+func (m *IRCManager) ircIgnoredDiscord(channel, user string) bool {
+	_, ret := m.bridge.settings(channel).DiscordIgnores[user]
 	return ret
 }
 
@@ -139,7 +172,7 @@ func (m *IRCManager) ircIgnoredDiscord(user string) bool {
 //
 // When `user.Online == false`, we make `user.ID` the only other data present in discord.handlePresenceUpdate
 func (m *IRCManager) HandleUser(user DiscordUser) {
-	if m.ircIgnoredDiscord(user.ID) {
+	if m.ircIgnoredDiscord("", user.ID) {
 		return
 	}
 
@@ -174,6 +207,9 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 			return
 		}
 
+		// Their roles might have changed which channels they can see
+		con.updateChannels()
+
 		// Update their nickname / username
 		// Note: this event is still called when their status is changed
 		//       from `online` to `dnd` (online related states)
@@ -212,6 +248,7 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		return
 	}
 
+	claimedNick, _ := m.nickClaims.Get(user.ID)
 	nick := m.generateNickname(user)
 	username := m.generateUsername(user)
 
@@ -239,6 +276,9 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		messages:         make(chan IRCMessage),
 		manager:          m,
 		pmNoticedSenders: make(map[string]struct{}),
+		mutedSenders:     make(map[string]struct{}),
+		claimedNick:      claimedNick,
+		rejoins:          newRejoinTracker(),
 		quitMessage:      fmt.Sprintf("Offline for %s", m.bridge.Config.CooldownDuration),
 	}
 
@@ -257,10 +297,15 @@ func (m *IRCManager) HandleUser(user DiscordUser) {
 		RealName: user.Username,
 
 		WebIRCSuffix: fmt.Sprintf("discord %s %s", hostname, ip),
+		RequestCaps:  ircCapabilities,
 
 		Callbacks: map[string]func(*irc.Event){
-			"001":     con.OnWelcome,
-			"PRIVMSG": con.OnPrivateMessage,
+			"001":         con.OnWelcome,
+			"PRIVMSG":     con.OnPrivateMessage,
+			"CTCP_ACTION": con.OnAction,
+			"KICK":        con.OnKick,
+			"474":         con.OnBannedFromChannel,
+			"INVITE":      con.OnInvite,
 		},
 	})
 	if err != nil {
@@ -308,6 +353,10 @@ func sanitiseNickname(nick string) string {
 }
 
 func (m *IRCManager) generateNickname(discord DiscordUser) string {
+	if nick, ok := m.claimedNickname(discord.ID); ok {
+		return nick
+	}
+
 	nick := sanitiseNickname(discord.Nick)
 	suffix := m.bridge.Config.Suffix
 	newNick := nick + suffix
@@ -319,13 +368,7 @@ func (m *IRCManager) generateNickname(discord DiscordUser) string {
 	// }).Infoln("nickgen: fallback?")
 
 	if !useFallback {
-		guild, err := m.bridge.discord.Session.State.Guild(m.bridge.Config.GuildID)
-		if err != nil {
-			// log.Fatalln("nickgen: guild not found when generating nickname")
-			return ""
-		}
-
-		for _, member := range guild.Members {
+		for _, member := range m.bridge.discord.members() {
 			if member.User.ID == discord.ID {
 				continue
 			}
@@ -380,7 +423,9 @@ func (m *IRCManager) generateNickname(discord DiscordUser) string {
 
 // SendMessage sends a broken down Discord Message to a particular IRC channel.
 func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
-	if m.ircIgnoredDiscord(msg.Author.ID) {
+	channel = strings.Split(channel, " ")[0]
+
+	if m.ircIgnoredDiscord(channel, msg.Author.ID) {
 		return
 	}
 
@@ -388,18 +433,34 @@ func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
 
 	content := msg.Content
 
-	channel = strings.Split(channel, " ")[0]
+	// Only remember public messages, reactions and such have no ID
+	remember := msg.PmTarget == "" && msg.ID != ""
 
 	// Person is appearing offline (or the bridge is running in Simple Mode)
 	if !ok {
 		length := len(msg.Author.Username)
 		for _, line := range strings.Split(content, "\n") {
-			m.bridge.ircListener.Privmsg(channel, fmt.Sprintf(
+			// Servers reject empty messages
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+
+			text := fmt.Sprintf(
 				"<%s#%s> %s",
 				msg.Author.Username[:1]+"\u200B"+msg.Author.Username[1:length],
 				msg.Author.Discriminator,
 				line,
-			))
+			)
+			if remember {
+				m.relayed.Sent(relayedMessage{
+					DiscordID:      msg.ID,
+					AuthorID:       msg.Author.ID,
+					Nick:           msg.Author.Username,
+					IRCChannel:     channel,
+					DiscordChannel: msg.ChannelID,
+				}, text)
+			}
+			m.bridge.ircListener.Privmsg(channel, text)
 		}
 		return
 	}
@@ -410,6 +471,11 @@ func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
 	}
 
 	for _, line := range strings.Split(content, "\n") {
+		// Servers reject empty messages
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
 		ircMessage := IRCMessage{
 			IRCChannel: channel,
 			Message:    line,
@@ -421,10 +487,21 @@ func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
 			ircMessage.Message = line[4:]
 		}
 
-		if m.isFilteredDiscordMessage(line) {
+		if m.isFilteredDiscordMessage(channel, line) {
 			continue
 		}
 
+		if remember {
+			ircMessage.relayed = &relayedMessage{
+				DiscordID:      msg.ID,
+				AuthorID:       msg.Author.ID,
+				UserID:         con.discord.ID,
+				Nick:           con.nick,
+				IRCChannel:     channel,
+				DiscordChannel: msg.ChannelID,
+			}
+		}
+
 		select {
 		// Try to send the message immediately
 		case con.messages <- ircMessage:
@@ -437,16 +514,115 @@ func (m *IRCManager) SendMessage(channel string, msg *DiscordMessage) {
 	}
 }
 
-// RequestChannels finds all the Discord channels this user belongs to,
-// and then find pairings in the global pairings list
-// Currently just returns all participating IRC channels
-// TODO (?)
+// A redaction is a REDACT command to send from a Discord user's
+// connection, or the listener if uid is blank
+type redaction struct {
+	uid     string
+	command string
+}
+
+// DeleteMessages relays the deletion of Discord messages to IRC. Messages are
+// redacted if the server supports it, otherwise a notice is sent instead.
+func (m *IRCManager) DeleteMessages(ids []string) {
+	var redactions []redaction
+	notices := make(map[string][]*relayedMessage) // by IRC channel
+
+	for _, id := range ids {
+		for _, msg := range m.relayed.Remove(id) {
+			if len(msg.IRCMsgIDs) == 0 || !m.canRedact(msg.UserID) {
+				notices[msg.IRCChannel] = append(notices[msg.IRCChannel], msg)
+				continue
+			}
+
+			for _, msgid := range msg.IRCMsgIDs {
+				redactions = append(redactions, redaction{
+					uid:     msg.UserID,
+					command: fmt.Sprintf("REDACT %s %s", msg.IRCChannel, msgid),
+				})
+			}
+		}
+	}
+
+	for channel, msgs := range notices {
+		// Summarise bulk deletions
+		if len(msgs) > deletionBurst {
+			m.bridge.ircListener.Notice(channel, fmt.Sprintf("[%d messages deleted]", len(msgs)))
+			continue
+		}
+
+		for _, msg := range msgs {
+			m.bridge.ircListener.Notice(channel, strings.ReplaceAll(m.bridge.Config.DeletionNotice, "${USERNAME}", msg.Nick))
+		}
+	}
+
+	if len(redactions) <= deletionBurst && len(m.redactions) == 0 {
+		for _, r := range redactions {
+			m.redact(r)
+		}
+		return
+	}
+
+	// Pace bulk redactions so that we don't get disconnected for flooding.
+	// The bridge loop sends the next one each time redactTimer fires.
+	m.redactions = append(m.redactions, redactions...)
+	if m.redactTimer == nil {
+		m.redactTimer = time.After(0)
+	}
+}
+
+// redactNext sends the next paced redaction
+func (m *IRCManager) redactNext() {
+	m.redactTimer = nil
+	if len(m.redactions) == 0 {
+		return
+	}
+
+	m.redact(m.redactions[0])
+	m.redactions = m.redactions[1:]
+	if len(m.redactions) > 0 {
+		m.redactTimer = time.After(deletionInterval)
+	}
+}
+
+func (m *IRCManager) redact(r redaction) {
+	if r.uid == "" {
+		m.bridge.ircListener.SendRaw(r.command)
+	} else if err := m.varys.SendRaw(r.uid, varys.InterpolationParams{}, r.command); err != nil {
+		log.WithError(err).WithField("discord", r.uid).Errorln("failed to redact message")
+	}
+}
+
+// canRedact returns whether the connection for a Discord user (or
+// the listener, if blank) is able to redact its own messages
+func (m *IRCManager) canRedact(uid string) bool {
+	if uid == "" {
+		return m.bridge.ircListener.HasCapability(redactionCapability)
+	}
+
+	ok, err := m.varys.HasCapability(uid, redactionCapability)
+	if err != nil {
+		log.WithError(err).WithField("discord", uid).Errorln("failed to check capability")
+		return false
+	}
+	return ok
+}
+
+// RequestChannels returns the mappings a user's puppet should be in:
+// those that Discord can send to, from channels the user can see
 func (m *IRCManager) RequestChannels(userID string) []Mapping {
-	return m.bridge.mappings
+	var channels []Mapping
+	for _, mapping := range mappingsToIRC(m.bridge.mappings) {
+		if m.bridge.discord.canView(userID, mapping.DiscordChannel) {
+			channels = append(channels, mapping)
+		}
+	}
+	return channels
 }
 
-func (m *IRCManager) isIgnoredHostmask(mask string) bool {
-	for _, ban := range m.bridge.Config.IRCIgnores {
+// isIgnoredHostmask returns whether an IRC user is ignored in a channel,
+// or everywhere if channel is blank or not a channel
+func (m *IRCManager) isIgnoredHostmask(channel, mask string) bool {
+	for _, ban := range m.bridge.settings(channel).IRCIgnores {
 		if ban.Match(mask) {
 			return true
 		}
@@ -454,8 +630,8 @@ func (m *IRCManager) isIgnoredHostmask(mask string) bool {
 	return false
 }
 
-func (m *IRCManager) isFilteredIRCMessage(txt string) bool {
-	for _, ban := range m.bridge.Config.IRCFilteredMessages {
+func (m *IRCManager) isFilteredIRCMessage(channel, txt string) bool {
+	for _, ban := range m.bridge.settings(channel).IRCFilteredMessages {
 		if ban.Match(txt) {
 			return true
 		}
@@ -463,8 +639,8 @@ func (m *IRCManager) isFilteredIRCMessage(txt string) bool {
 	return false
 }
 
-func (m *IRCManager) isFilteredDiscordMessage(txt string) bool {
-	for _, ban := range m.bridge.Config.DiscordFilteredMessages {
+func (m *IRCManager) isFilteredDiscordMessage(channel, txt string) bool {
+	for _, ban := range m.bridge.settings(channel).DiscordFilteredMessages {
 		if ban.Match(txt) {
 			return true
 		}
@@ -0,0 +1,190 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	irc "github.com/qaisjp/go-ircevent"
+)
+
+// whoisTimeout is how long to wait for the server to answer a WHOIS
+const whoisTimeout = 10 * time.Second
+
+// errNoSuchNick is returned by LookupWhois if the nick isn't online
+var errNoSuchNick = errors.New("no such nick")
+
+// A whoisReply is what the server told us about a nick
+type whoisReply struct {
+	Nick     string
+	User     string
+	Host     string
+	RealName string
+	Server   string
+	Account  string
+	Channels []string
+}
+
+func (w *whoisReply) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s@%s): %s", w.Nick, w.User, w.Host, w.RealName)
+	if w.Account != "" {
+		fmt.Fprintf(&b, "\nlogged in as %s", w.Account)
+	}
+	if w.Server != "" {
+		fmt.Fprintf(&b, "\nconnected to %s", w.Server)
+	}
+	if len(w.Channels) > 0 {
+		fmt.Fprintf(&b, "\nin %s", strings.Join(w.Channels, " "))
+	}
+	return b.String()
+}
+
+type pendingWhois struct {
+	reply whoisReply
+	err   error
+	done  chan struct{}
+}
+
+// whoisTracker matches WHOIS replies to the lookups waiting for them.
+//
+// It is safe to use from multiple goroutines.
+type whoisTracker struct {
+	mu      sync.Mutex
+	pending map[string]*pendingWhois // by lowercase nick
+}
+
+func newWhoisTracker() *whoisTracker {
+	return &whoisTracker{pending: make(map[string]*pendingWhois)}
+}
+
+// addCallbacks registers the callbacks for WHOIS replies on a connection
+func (t *whoisTracker) addCallbacks(con *irc.Connection) {
+	con.AddCallback("311", t.onWhoisUser)
+	con.AddCallback("312", t.onWhoisServer)
+	con.AddCallback("319", t.onWhoisChannels)
+	con.AddCallback("330", t.onWhoisAccount)
+	con.AddCallback("318", t.onEndOfWhois)
+	con.AddCallback("401", t.onNoSuchNick)
+}
+
+// Lookup sends a WHOIS for a nick using send, and waits for the reply
+func (t *whoisTracker) Lookup(nick string, send func(nick string)) (whoisReply, error) {
+	key := strings.ToLower(nick)
+
+	t.mu.Lock()
+	p, ok := t.pending[key]
+	if !ok {
+		p = &pendingWhois{done: make(chan struct{})}
+		t.pending[key] = p
+	}
+	t.mu.Unlock()
+
+	// Only one WHOIS is sent for concurrent lookups of the same nick
+	if !ok {
+		send(nick)
+	}
+
+	select {
+	case <-p.done:
+		return p.reply, p.err
+	case <-time.After(whoisTimeout):
+		t.mu.Lock()
+		if t.pending[key] == p {
+			delete(t.pending, key)
+		}
+		t.mu.Unlock()
+		return whoisReply{}, errors.New("timed out waiting for WHOIS reply")
+	}
+}
+
+// update applies fn to the lookup waiting for a reply about nick, if any
+func (t *whoisTracker) update(nick string, fn func(p *pendingWhois)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, ok := t.pending[strings.ToLower(nick)]; ok {
+		fn(p)
+	}
+}
+
+// finish completes the lookup for nick
+func (t *whoisTracker) finish(nick string, err error) {
+	key := strings.ToLower(nick)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if p, ok := t.pending[key]; ok {
+		p.err = err
+		close(p.done)
+		delete(t.pending, key)
+	}
+}
+
+// RPL_WHOISUSER: <client> <nick> <username> <host> * :<realname>
+func (t *whoisTracker) onWhoisUser(e *irc.Event) {
+	if len(e.Arguments) < 6 {
+		return
+	}
+
+	t.update(e.Arguments[1], func(p *pendingWhois) {
+		p.reply.Nick = e.Arguments[1]
+		p.reply.User = e.Arguments[2]
+		p.reply.Host = e.Arguments[3]
+		p.reply.RealName = e.Arguments[5]
+	})
+}
+
+// RPL_WHOISSERVER: <client> <nick> <server> :<server info>
+func (t *whoisTracker) onWhoisServer(e *irc.Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+
+	t.update(e.Arguments[1], func(p *pendingWhois) {
+		p.reply.Server = e.Arguments[2]
+	})
+}
+
+// RPL_WHOISCHANNELS: <client> <nick> :[prefix]<channel>{ [prefix]<channel>}
+func (t *whoisTracker) onWhoisChannels(e *irc.Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+
+	t.update(e.Arguments[1], func(p *pendingWhois) {
+		p.reply.Channels = append(p.reply.Channels, strings.Fields(e.Arguments[2])...)
+	})
+}
+
+// RPL_WHOISACCOUNT: <client> <nick> <account> :is logged in as
+func (t *whoisTracker) onWhoisAccount(e *irc.Event) {
+	if len(e.Arguments) < 3 {
+		return
+	}
+
+	t.update(e.Arguments[1], func(p *pendingWhois) {
+		p.reply.Account = e.Arguments[2]
+	})
+}
+
+// RPL_ENDOFWHOIS: <client> <nick> :End of /WHOIS list
+func (t *whoisTracker) onEndOfWhois(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	t.finish(e.Arguments[1], nil)
+}
+
+// ERR_NOSUCHNICK: <client> <nickname> :No such nick/channel
+func (t *whoisTracker) onNoSuchNick(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	t.finish(e.Arguments[1], errNoSuchNick)
+}
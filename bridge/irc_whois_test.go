@@ -0,0 +1,34 @@
+package bridge
+
+import (
+	"testing"
+
+	irc "github.com/qaisjp/go-ircevent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhoisTracker(t *testing.T) {
+	tracker := newWhoisTracker()
+
+	reply, err := tracker.Lookup("Alice", func(nick string) {
+		assert.Equal(t, "Alice", nick)
+
+		// Replies arrive from the server while the lookup is waiting
+		go func() {
+			tracker.onWhoisUser(&irc.Event{Arguments: []string{"bridge", "alice", "al", "example.com", "*", "Alice Smith"}})
+			tracker.onWhoisChannels(&irc.Event{Arguments: []string{"bridge", "alice", "@#chan +#other"}})
+			tracker.onWhoisAccount(&irc.Event{Arguments: []string{"bridge", "alice", "alice", "is logged in as"}})
+			tracker.onWhoisUser(&irc.Event{Arguments: []string{"bridge", "bob", "bob", "example.com", "*", "Bob"}})
+			tracker.onEndOfWhois(&irc.Event{Arguments: []string{"bridge", "ALICE", "End of /WHOIS list"}})
+		}()
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "alice (al@example.com): Alice Smith\nlogged in as alice\nin @#chan +#other", reply.String())
+	assert.Empty(t, tracker.pending)
+
+	_, err = tracker.Lookup("nobody", func(nick string) {
+		go tracker.onNoSuchNick(&irc.Event{Arguments: []string{"bridge", "nobody", "No such nick/channel"}})
+	})
+	assert.Equal(t, errNoSuchNick, err)
+}
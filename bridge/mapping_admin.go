@@ -0,0 +1,215 @@
+package bridge
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// mappingChangesKey is where mappingChanges are saved in the store
+const mappingChangesKey = "channel_mappings"
+
+// mappingChanges are changes to channel_mappings made from Discord.
+// They are saved separately from the config, so that the config can
+// still be edited by hand.
+type mappingChanges struct {
+	Added   map[string]string `json:"added"`   // from IRC channel (and key) to Discord channel, like channel_mappings
+	Removed []removedMapping  `json:"removed"` // mappings removed from channel_mappings
+}
+
+// A removedMapping is a mapping of an IRC channel to a Discord channel
+// that was removed from channel_mappings
+type removedMapping struct {
+	IRC     string `json:"irc"` // the IRC channel, without its key
+	Discord string `json:"discord"`
+}
+
+// matches returns whether this is the mapping of an IRC channel
+// (which can be followed by its key) to a Discord channel entry
+func (r removedMapping) matches(irc, entry string) bool {
+	return strings.EqualFold(r.IRC, ircChannelName(irc)) && r.Discord == entryChannel(entry)
+}
+
+// ircChannelName returns the channel name from a channel_mappings key,
+// which can also have a channel key, like "#channel key"
+func ircChannelName(irc string) string {
+	return strings.SplitN(irc, " ", 2)[0]
+}
+
+// mergeMappings applies changes made from Discord to channel_mappings
+func mergeMappings(config map[string]string, changes mappingChanges) map[string]string {
+	channels := make(map[string][]string, len(config)+len(changes.Added))
+	for irc, discord := range config {
+		for _, entry := range discordChannels(discord) {
+			if !changes.removed(irc, entry) {
+				channels[irc] = appendChannel(channels[irc], entry)
+			}
+		}
+	}
+	for irc, discord := range changes.Added {
+		for _, entry := range discordChannels(discord) {
+			channels[irc] = appendChannel(channels[irc], entry)
+		}
+	}
+
+	merged := make(map[string]string, len(channels))
+	for irc, discord := range channels {
+		merged[irc] = strings.Join(discord, ",")
+	}
+	return merged
+}
+
+// removed returns whether the mapping of an IRC channel to a Discord
+// channel entry was removed
+func (c mappingChanges) removed(irc, entry string) bool {
+	for _, r := range c.Removed {
+		if r.matches(irc, entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryChannel returns the Discord channel of a channel_mappings entry,
+// without its direction
+func entryChannel(entry string) string {
+	return strings.SplitN(entry, " ", 2)[0]
+}
+
+// appendChannel adds a channel_mappings entry to a list, replacing any entry
+// for the same Discord channel
+func appendChannel(entries []string, entry string) []string {
+	for i, e := range entries {
+		if entryChannel(e) == entryChannel(entry) {
+			entries[i] = entry
+			return entries
+		}
+	}
+	return append(entries, entry)
+}
+
+// with returns changes that also bridge an IRC channel (which can be
+// followed by its key) to a Discord channel, in a direction
+func (c mappingChanges) with(irc, discordChannel string, direction Direction) mappingChanges {
+	result := mappingChanges{Added: make(map[string]string, len(c.Added)+1), Removed: c.Removed}
+	for k, v := range c.Added {
+		result.Added[k] = v
+	}
+	entries := appendChannel(discordChannels(result.Added[irc]), mappingEntry(discordChannel, direction))
+	result.Added[irc] = strings.Join(entries, ",")
+	return result
+}
+
+// without returns changes without the mapping of an IRC channel to a
+// Discord channel, or of any IRC channel if ircChannel is blank
+func (c mappingChanges) without(config map[string]string, ircChannel, discordChannel string) mappingChanges {
+	mapped := func(irc, entry string) bool {
+		return entryChannel(entry) == discordChannel &&
+			(ircChannel == "" || strings.EqualFold(ircChannelName(irc), ircChannel))
+	}
+
+	result := mappingChanges{Added: make(map[string]string), Removed: c.Removed}
+	for irc, discord := range c.Added {
+		var entries []string
+		for _, entry := range discordChannels(discord) {
+			if !mapped(irc, entry) {
+				entries = append(entries, entry)
+			}
+		}
+		if len(entries) > 0 {
+			result.Added[irc] = strings.Join(entries, ",")
+		}
+	}
+
+	for irc, discord := range config {
+		for _, entry := range discordChannels(discord) {
+			if mapped(irc, entry) && !result.removed(irc, entry) {
+				result.Removed = append(result.Removed, removedMapping{IRC: ircChannelName(irc), Discord: discordChannel})
+			}
+		}
+	}
+	sort.Slice(result.Removed, func(i, j int) bool {
+		a, b := result.Removed[i], result.Removed[j]
+		if a.Discord != b.Discord {
+			return a.Discord < b.Discord
+		}
+		return strings.ToLower(a.IRC) < strings.ToLower(b.IRC)
+	})
+
+	return result
+}
+
+// mappingChanges returns the changes to channel_mappings made from Discord
+func (b *Bridge) mappingChanges() mappingChanges {
+	var changes mappingChanges
+	if b.store == nil {
+		return changes
+	}
+
+	if _, err := b.store.Get(mappingChangesKey, &changes); err != nil {
+		log.WithError(err).Errorln("could not read channel mapping changes, ignoring them")
+		return mappingChanges{}
+	}
+	return changes
+}
+
+// changeMappings applies and saves a change to the mappings
+func (b *Bridge) changeMappings(change func(mappingChanges) mappingChanges) error {
+	if b.store == nil {
+		return errors.New("mappings can't be changed from Discord without a state_file")
+	}
+
+	b.mappingChangesMu.Lock()
+	defer b.mappingChangesMu.Unlock()
+
+	changes := change(b.mappingChanges())
+
+	// The puppets belong to the loop
+	var err error
+	b.inLoop(func() {
+		err = b.setChannelMappings(mergeMappings(b.configMappings, changes))
+	})
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(b.store.Set(mappingChangesKey, changes), "mappings changed, but could not be saved")
+}
+
+// AddMapping bridges an IRC channel (which can be followed by a space and
+// its key) to a Discord channel, as well as any other channels they are
+// bridged to. Bridging them again changes the direction.
+func (b *Bridge) AddMapping(irc, discordChannel string, direction Direction) error {
+	for _, mapping := range b.GetMappingsByDiscord(discordChannel) {
+		if strings.EqualFold(mapping.IRCChannel, ircChannelName(irc)) && mapping.Direction == direction {
+			return errors.Errorf("that channel is already bridged to %s", mapping.IRCChannel)
+		}
+	}
+
+	return b.changeMappings(func(changes mappingChanges) mappingChanges {
+		changes = changes.without(b.configMappings, ircChannelName(irc), discordChannel)
+		return changes.with(irc, discordChannel, direction)
+	})
+}
+
+// RemoveMapping stops bridging a Discord channel to an IRC channel,
+// or to any IRC channel if ircChannel is blank
+func (b *Bridge) RemoveMapping(discordChannel, ircChannel string) error {
+	found := false
+	for _, mapping := range b.GetMappingsByDiscord(discordChannel) {
+		if ircChannel == "" || strings.EqualFold(mapping.IRCChannel, ircChannel) {
+			found = true
+		}
+	}
+	if !found && ircChannel != "" {
+		return errors.Errorf("that channel isn't bridged to %s", ircChannel)
+	} else if !found {
+		return errors.New("that channel isn't bridged")
+	}
+
+	return b.changeMappings(func(changes mappingChanges) mappingChanges {
+		return changes.without(b.configMappings, ircChannel, discordChannel)
+	})
+}
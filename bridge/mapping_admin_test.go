@@ -0,0 +1,61 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMappingChanges(t *testing.T) {
+	config := map[string]string{
+		"#a key": "1",
+		"#b":     "2,3",
+	}
+
+	// Bridging #b to another channel adds to the config's mappings of #b
+	changes := mappingChanges{}.with("#b", "4", DirectionBoth)
+	assert.Equal(t, map[string]string{"#a key": "1", "#b": "2,3,4"}, mergeMappings(config, changes))
+
+	// Bridging channel 1 to #c keeps its mapping to #a
+	changes = changes.with("#c", "1", DirectionBoth)
+	assert.Equal(t, map[string]string{"#a key": "1", "#b": "2,3,4", "#c": "1"}, mergeMappings(config, changes))
+
+	// Bridging a channel twice doesn't duplicate it
+	assert.Equal(t, mergeMappings(config, changes), mergeMappings(config, changes.with("#c", "1", DirectionBoth)))
+
+	// Bridging again changes the direction
+	changes = changes.with("#c", "1", DirectionIRCToDiscord)
+	assert.Equal(t, "1 irc-to-discord", mergeMappings(config, changes)["#c"])
+
+	// Removing a mapping from the config keeps the channel's other mappings
+	changes = changes.without(config, "#A", "1")
+	assert.Equal(t, []removedMapping{{IRC: "#a", Discord: "1"}}, changes.Removed)
+	assert.Equal(t, map[string]string{"#b": "2,3,4", "#c": "1 irc-to-discord"}, mergeMappings(config, changes))
+
+	// Removing every mapping of a channel, from the config and from changes
+	changes = changes.without(config, "", "1")
+	assert.Len(t, changes.Removed, 1)
+	changes = changes.without(config, "", "3")
+	assert.Equal(t, []removedMapping{{IRC: "#a", Discord: "1"}, {IRC: "#b", Discord: "3"}}, changes.Removed)
+	assert.Equal(t, map[string]string{"#b": "2,4"}, mergeMappings(config, changes))
+
+	// Mappings added to the config later aren't removed
+	config["#d"] = "1,3"
+	assert.Equal(t, map[string]string{"#b": "2,4", "#d": "1,3"}, mergeMappings(config, changes))
+
+	// Bridging a removed mapping again
+	changes = changes.with("#a", "1", DirectionBoth)
+	assert.Equal(t, "1", mergeMappings(config, changes)["#a"])
+
+	// No changes
+	assert.Equal(t, config, mergeMappings(config, mappingChanges{}))
+}
+
+func TestValidIRCChannel(t *testing.T) {
+	assert.True(t, validIRCChannel("#chan"))
+	assert.True(t, validIRCChannel("&local"))
+	assert.False(t, validIRCChannel("#"))
+	assert.False(t, validIRCChannel("chan"))
+	assert.False(t, validIRCChannel("#a,#b"))
+	assert.False(t, validIRCChannel("#a b"))
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// Formatting policies for messages from IRC
+const (
+	// FormattingMarkdown turns IRC formatting into Discord markdown
+	FormattingMarkdown = "markdown"
+	// FormattingStrip removes IRC formatting
+	FormattingStrip = "strip"
+)
+
+// MappingSettings override the global settings for an IRC channel's
+// mappings. Fields that aren't set (nil or blank) use the global setting.
+type MappingSettings struct {
+	ShowJoinQuit *bool
+
+	IRCIgnores     []glob.Glob
+	DiscordIgnores map[string]struct{}
+
+	IRCFilteredMessages     []glob.Glob
+	DiscordFilteredMessages []glob.Glob
+
+	AvatarURL string
+
+	// Formatting is FormattingMarkdown or FormattingStrip
+	Formatting string
+}
+
+// settings returns the settings in effect for an IRC channel, which are
+// the global settings if channel is blank
+func (b *Bridge) settings(channel string) MappingSettings {
+	var s MappingSettings
+	if c := b.Config.ChannelSettings[strings.ToLower(channel)]; c != nil && channel != "" {
+		s = *c
+	}
+
+	if s.ShowJoinQuit == nil {
+		s.ShowJoinQuit = &b.Config.ShowJoinQuit
+	}
+	if s.IRCIgnores == nil {
+		s.IRCIgnores = b.Config.IRCIgnores
+	}
+	if s.DiscordIgnores == nil {
+		s.DiscordIgnores = b.Config.DiscordIgnores
+	}
+	if s.IRCFilteredMessages == nil {
+		s.IRCFilteredMessages = b.Config.IRCFilteredMessages
+	}
+	if s.DiscordFilteredMessages == nil {
+		s.DiscordFilteredMessages = b.Config.DiscordFilteredMessages
+	}
+	if s.AvatarURL == "" {
+		s.AvatarURL = b.Config.AvatarURL
+	}
+	if s.Formatting == "" {
+		s.Formatting = FormattingMarkdown
+	}
+	return s
+}
+
+// showJoinQuit returns whether joins, parts, quits and kicks in an IRC
+// channel are shown on Discord
+func (b *Bridge) showJoinQuit(channel string) bool {
+	return *b.settings(channel).ShowJoinQuit
+}
+
+// anyShowJoinQuit returns whether joins and quits are shown in any channel
+func (b *Bridge) anyShowJoinQuit() bool {
+	if b.Config.ShowJoinQuit {
+		return true
+	}
+	for _, s := range b.Config.ChannelSettings {
+		if s != nil && s.ShowJoinQuit != nil && *s.ShowJoinQuit {
+			return true
+		}
+	}
+	return false
+}
+
+// SetChannelSettings allows you to update the settings by IRC channel
+func (b *Bridge) SetChannelSettings(settings map[string]*MappingSettings) {
+	b.Config.ChannelSettings = settings
+	b.ircListener.OnJoinQuitSettingChange()
+}
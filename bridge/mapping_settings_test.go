@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/gobwas/glob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings(t *testing.T) {
+	show, hide := true, false
+	b := &Bridge{Config: &Config{
+		AvatarURL:           "https://example.com/${USERNAME}.png",
+		IRCFilteredMessages: []glob.Glob{glob.MustCompile("*spam*")},
+		DiscordIgnores:      map[string]struct{}{"1": {}},
+		ChannelSettings: map[string]*MappingSettings{
+			"#support": {ShowJoinQuit: &show, Formatting: FormattingStrip},
+			"#busy":    {ShowJoinQuit: &hide, IRCFilteredMessages: []glob.Glob{}, DiscordIgnores: map[string]struct{}{"2": {}}},
+		},
+	}}
+
+	tests := []struct {
+		name       string
+		channel    string
+		joinQuit   bool
+		formatting string
+		filtered   bool
+		ignored    string
+	}{
+		{"global", "", false, FormattingMarkdown, true, "1"},
+		{"no settings", "#other", false, FormattingMarkdown, true, "1"},
+		{"some settings", "#Support", true, FormattingStrip, true, "1"},
+		{"replaced lists", "#busy", false, FormattingMarkdown, false, "2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := b.settings(tt.channel)
+			assert.Equal(t, tt.joinQuit, *s.ShowJoinQuit)
+			assert.Equal(t, tt.formatting, s.Formatting)
+			assert.Equal(t, b.Config.AvatarURL, s.AvatarURL)
+			assert.Equal(t, tt.filtered, len(s.IRCFilteredMessages) > 0)
+			assert.Contains(t, s.DiscordIgnores, tt.ignored)
+		})
+	}
+
+	assert.True(t, b.anyShowJoinQuit())
+}
@@ -0,0 +1,252 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// Puppets kicked this many times in kickWindow stop rejoining the channel
+const (
+	maxKicks   = 3
+	kickWindow = 5 * time.Minute
+)
+
+// rejoinTracker decides whether a puppet should rejoin channels it is
+// kicked from, so that it doesn't fight with IRC operators.
+//
+// It is safe to use from multiple goroutines.
+type rejoinTracker struct {
+	mu     sync.Mutex
+	kicks  map[string][]time.Time // by lowercase channel
+	banned map[string]bool        // by lowercase channel
+}
+
+func newRejoinTracker() *rejoinTracker {
+	return &rejoinTracker{
+		kicks:  make(map[string][]time.Time),
+		banned: make(map[string]bool),
+	}
+}
+
+// Kicked records a kick, and returns whether to rejoin the channel
+func (r *rejoinTracker) Kicked(channel string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := strings.ToLower(channel)
+	var recent []time.Time
+	for _, t := range r.kicks[key] {
+		if now.Sub(t) < kickWindow {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	r.kicks[key] = recent
+
+	if len(recent) >= maxKicks {
+		r.banned[key] = true
+	}
+	return !r.banned[key]
+}
+
+// Ban stops the puppet joining a channel
+func (r *rejoinTracker) Ban(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.banned[strings.ToLower(channel)] = true
+}
+
+// Unban lets the puppet join a channel again
+func (r *rejoinTracker) Unban(channel string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := strings.ToLower(channel)
+	delete(r.banned, key)
+	delete(r.kicks, key)
+}
+
+func (r *rejoinTracker) IsBanned(channel string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.banned[strings.ToLower(channel)]
+}
+
+// puppetHostmask matches the puppet of a Discord user, whatever its nick
+func puppetHostmask(user *discordgo.User) string {
+	if user.Bot {
+		return "*!*@" + user.ID + ".bot.discord"
+	}
+	return "*!*@" + user.ID + ".user.discord"
+}
+
+// channels returns the mappings the puppet should be in,
+// without channels it has been banned from
+func (i *ircConnection) channels() []Mapping {
+	var channels []Mapping
+	for _, mapping := range i.manager.RequestChannels(i.discord.ID) {
+		if !i.rejoins.IsBanned(mapping.IRCChannel) {
+			channels = append(channels, mapping)
+		}
+	}
+	return channels
+}
+
+// OnKick rejoins channels the puppet is kicked from,
+// unless it keeps getting kicked
+func (i *ircConnection) OnKick(e *irc.Event) {
+	if len(e.Arguments) < 2 || e.Arguments[1] != i.GetNick() {
+		return
+	}
+
+	channel := e.Arguments[0]
+	reason := e.Message()
+	mapping, ok := i.channelMapping(channel)
+
+	if !ok || !i.rejoins.Kicked(channel, time.Now()) {
+		i.manager.bridge.discord.modLog(fmt.Sprintf("%s was kicked from %s on IRC by %s (%s) and won't rejoin", i.modLogName(), channel, e.Nick, reason))
+		return
+	}
+
+	i.manager.bridge.discord.modLog(fmt.Sprintf("%s was kicked from %s on IRC by %s (%s)", i.modLogName(), channel, e.Nick, reason))
+	i.SendRaw(i.manager.bridge.GetJoinCommand([]Mapping{mapping}))
+}
+
+// ERR_BANNEDFROMCHAN: <client> <channel> :Cannot join channel (+b)
+func (i *ircConnection) OnBannedFromChannel(e *irc.Event) {
+	if len(e.Arguments) < 2 || i.rejoins.IsBanned(e.Arguments[1]) {
+		return
+	}
+
+	i.rejoins.Ban(e.Arguments[1])
+	i.manager.bridge.discord.modLog(fmt.Sprintf("%s is banned from %s on IRC, and won't try to join it again", i.modLogName(), e.Arguments[1]))
+}
+
+// OnInvite joins channels the puppet was banned from, when invited to them
+func (i *ircConnection) OnInvite(e *irc.Event) {
+	if len(e.Arguments) < 2 {
+		return
+	}
+
+	channel := e.Arguments[1]
+	mapping, ok := i.channelMapping(channel)
+	if !ok || !i.rejoins.IsBanned(channel) {
+		return
+	}
+
+	i.rejoins.Unban(channel)
+	i.SendRaw(i.manager.bridge.GetJoinCommand([]Mapping{mapping}))
+}
+
+func (i *ircConnection) modLogName() string {
+	return fmt.Sprintf("`%s` (<@%s>)", i.nick, i.discord.ID)
+}
+
+// modLog sends a message to the mod_log_channel, if there is one
+func (d *discordBot) modLog(text string) {
+	channel := d.bridge.Config.ModLogChannel
+	if channel == "" {
+		return
+	}
+
+	_, err := d.Session.ChannelMessageSendComplex(channel, &discordgo.MessageSend{
+		Content:         text,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	})
+	if err != nil {
+		log.WithError(err).Errorln("could not send to mod_log_channel")
+	}
+}
+
+// guildIRCChannels returns the IRC channels bridged to a Discord guild
+func (b *Bridge) guildIRCChannels(guildID string) []string {
+	var channels []string
+	seen := make(map[string]bool, len(b.mappings))
+	for _, mapping := range b.mappings {
+		if !seen[strings.ToLower(mapping.IRCChannel)] && b.discord.channelGuild(mapping.DiscordChannel) == guildID {
+			seen[strings.ToLower(mapping.IRCChannel)] = true
+			channels = append(channels, mapping.IRCChannel)
+		}
+	}
+	return channels
+}
+
+// setPuppetMode sets a channel mode on a puppet's hostmask, like "+b", in
+// every channel bridged to the guild that the listener is an operator in
+func (b *Bridge) setPuppetMode(guildID, mode string, user *discordgo.User) {
+	mask := puppetHostmask(user)
+	var set, notSet []string
+	for _, channel := range b.guildIRCChannels(guildID) {
+		if !b.ircListener.ops.Has(channel) {
+			notSet = append(notSet, channel)
+			continue
+		}
+		b.ircListener.SendRawf("MODE %s %s %s", channel, mode, mask)
+		set = append(set, channel)
+	}
+
+	if len(set) > 0 {
+		b.discord.modLog(fmt.Sprintf("Set %s %s for <@%s> in %s", mode, mask, user.ID, strings.Join(set, ", ")))
+	}
+	if len(notSet) > 0 {
+		b.discord.modLog(fmt.Sprintf("Could not set %s %s for <@%s> in %s, the bridge isn't an operator", mode, mask, user.ID, strings.Join(notSet, ", ")))
+	}
+}
+
+// unsetMode returns the mode that undoes a mode, like "-b" for "+b"
+func unsetMode(mode string) string {
+	return "-" + strings.TrimPrefix(mode, "+")
+}
+
+func (d *discordBot) onGuildBanAdd(s *discordgo.Session, m *discordgo.GuildBanAdd) {
+	if d.bridge.Config.ModerationBridging && d.isGuild(m.GuildID) {
+		d.bridge.setPuppetMode(m.GuildID, "+b", m.User)
+	}
+}
+
+func (d *discordBot) onGuildBanRemove(s *discordgo.Session, m *discordgo.GuildBanRemove) {
+	if d.bridge.Config.ModerationBridging && d.isGuild(m.GuildID) {
+		d.bridge.setPuppetMode(m.GuildID, "-b", m.User)
+	}
+}
+
+// onMemberTimeout quiets the puppets of people timed out on Discord,
+// until their timeout ends
+func (d *discordBot) onMemberTimeout(s *discordgo.Session, m *discordgo.GuildMemberUpdate) {
+	if !d.bridge.Config.ModerationBridging || m.User == nil || !d.isGuild(m.GuildID) {
+		return
+	}
+
+	until := m.CommunicationDisabledUntil
+	timedOut := until != nil && until.After(time.Now())
+	user, guildID := m.User, m.GuildID
+	key := guildID + " " + user.ID
+	quiet := d.bridge.Config.IRCQuietMode
+
+	d.timeoutsMu.Lock()
+	defer d.timeoutsMu.Unlock()
+
+	timer, quieted := d.timeouts[key]
+	switch {
+	case timedOut && quieted:
+		timer.Reset(time.Until(*until))
+	case timedOut:
+		d.bridge.setPuppetMode(guildID, quiet, user)
+		d.timeouts[key] = time.AfterFunc(time.Until(*until), func() {
+			d.timeoutsMu.Lock()
+			delete(d.timeouts, key)
+			d.timeoutsMu.Unlock()
+
+			d.bridge.setPuppetMode(guildID, unsetMode(quiet), user)
+		})
+	case quieted:
+		timer.Stop()
+		delete(d.timeouts, key)
+		d.bridge.setPuppetMode(guildID, unsetMode(quiet), user)
+	}
+}
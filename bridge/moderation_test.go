@@ -0,0 +1,58 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRejoinTracker(t *testing.T) {
+	r := newRejoinTracker()
+	now := time.Now()
+
+	assert.True(t, r.Kicked("#chan", now))
+	assert.True(t, r.Kicked("#chan", now.Add(time.Minute)))
+	assert.True(t, r.Kicked("#other", now.Add(time.Minute)), "kicks are counted per channel")
+
+	// The first kick is too old to count
+	assert.True(t, r.Kicked("#chan", now.Add(kickWindow+time.Second)))
+	assert.False(t, r.Kicked("#CHAN", now.Add(kickWindow+2*time.Second)))
+	assert.True(t, r.IsBanned("#chan"))
+
+	r.Unban("#chan")
+	assert.False(t, r.IsBanned("#chan"))
+	assert.True(t, r.Kicked("#chan", now.Add(kickWindow+3*time.Second)))
+
+	r.Ban("#other")
+	assert.True(t, r.IsBanned("#Other"))
+	assert.False(t, r.Kicked("#other", now.Add(time.Hour)))
+}
+
+func TestPuppetHostmask(t *testing.T) {
+	assert.Equal(t, "*!*@123.user.discord", puppetHostmask(&discordgo.User{ID: "123"}))
+	assert.Equal(t, "*!*@123.bot.discord", puppetHostmask(&discordgo.User{ID: "123", Bot: true}))
+}
+
+func TestUnsetMode(t *testing.T) {
+	assert.Equal(t, "-b", unsetMode("+b"))
+	assert.Equal(t, "-q", unsetMode("q"))
+}
+
+func TestGuildIRCChannels(t *testing.T) {
+	state := discordgo.NewState()
+	assert.NoError(t, state.GuildAdd(&discordgo.Guild{ID: "g1", Channels: []*discordgo.Channel{{ID: "1", GuildID: "g1"}, {ID: "2", GuildID: "g1"}}}))
+	assert.NoError(t, state.GuildAdd(&discordgo.Guild{ID: "g2", Channels: []*discordgo.Channel{{ID: "3", GuildID: "g2"}}}))
+
+	b := &Bridge{mappings: []Mapping{
+		{IRCChannel: "#a", DiscordChannel: "1"},
+		{IRCChannel: "#A", DiscordChannel: "2"},
+		{IRCChannel: "#b", DiscordChannel: "3"},
+		{IRCChannel: "#c", DiscordChannel: "unknown"},
+	}}
+	b.discord = &discordBot{Session: &discordgo.Session{State: state}, guildID: "g1", bridge: b}
+
+	assert.Equal(t, []string{"#a", "#c"}, b.guildIRCChannels("g1"), "unknown channels are in the main guild")
+	assert.Equal(t, []string{"#b"}, b.guildIRCChannels("g2"))
+}
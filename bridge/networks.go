@@ -0,0 +1,68 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// AddNetwork bridges another IRC network, using the same Discord session as
+// this bridge. Its Discord settings, like the guilds, are taken from this
+// bridge's config. It must be called before Open.
+func (b *Bridge) AddNetwork(conf *Config) (*Bridge, error) {
+	main := b.main()
+	for _, network := range main.allNetworks() {
+		if strings.EqualFold(network.Config.Discriminator, conf.Discriminator) {
+			return nil, errors.Errorf("irc_server_name %q is used by more than one network", conf.Discriminator)
+		}
+	}
+
+	conf.GuildID = main.Config.GuildID
+	conf.ExtraGuildIDs = main.Config.ExtraGuildIDs
+
+	network, err := newBridge(conf, main)
+	if err != nil {
+		return nil, err
+	}
+
+	main.networks = append(main.networks, network)
+	return network, nil
+}
+
+// main returns the bridge that owns the Discord session
+func (b *Bridge) main() *Bridge {
+	if b.primary != nil {
+		return b.primary
+	}
+	return b
+}
+
+// allNetworks returns every bridge sharing this Discord session, the main one first
+func (b *Bridge) allNetworks() []*Bridge {
+	main := b.main()
+	return append([]*Bridge{main}, main.networks...)
+}
+
+// commandNetwork returns the bridge that should handle a command used in a
+// Discord channel: the network called network, if it isn't blank, or else the
+// first network the channel is bridged to, or else the main one. Returns
+// false if there is no network called network.
+func (b *Bridge) commandNetwork(channelID, network string) (*Bridge, bool) {
+	networks := b.allNetworks()
+
+	if network != "" {
+		for _, n := range networks {
+			if strings.EqualFold(n.Config.Discriminator, network) {
+				return n, true
+			}
+		}
+		return nil, false
+	}
+
+	for _, n := range networks {
+		if _, ok := n.GetMappingByDiscord(channelID); ok {
+			return n, true
+		}
+	}
+	return networks[0], true
+}
@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandNetwork(t *testing.T) {
+	main := &Bridge{
+		Config:   &Config{Discriminator: "libera"},
+		mappings: []Mapping{{DiscordChannel: "1", IRCChannel: "#a"}},
+	}
+	oftc := &Bridge{
+		Config:   &Config{Discriminator: "oftc"},
+		mappings: []Mapping{{DiscordChannel: "2", IRCChannel: "#b"}},
+		primary:  main,
+	}
+	main.networks = []*Bridge{oftc}
+
+	tests := []struct {
+		name      string
+		channelID string
+		network   string
+		expected  *Bridge
+	}{
+		{"bridged to main", "1", "", main},
+		{"bridged to other", "2", "", oftc},
+		{"not bridged", "3", "", main},
+		{"named network", "1", "OFTC", oftc},
+		{"named main network", "2", "libera", main},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, b := range []*Bridge{main, oftc} {
+				network, ok := b.commandNetwork(tt.channelID, tt.network)
+				assert.True(t, ok)
+				assert.Same(t, tt.expected, network)
+			}
+		})
+	}
+
+	network, ok := oftc.commandNetwork("2", "efnet")
+	assert.False(t, ok, "unknown network")
+	assert.Nil(t, network)
+}
@@ -0,0 +1,192 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	ircnick "github.com/qaisjp/go-discord-irc/irc/nick"
+	"github.com/qaisjp/go-discord-irc/store"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// nickClaimsKey is where nick claims are saved in the store
+const nickClaimsKey = "nicks"
+
+// nickClaims are the IRC nicks Discord users have chosen for themselves,
+// used instead of their display name. The puppet suffix is still added.
+//
+// It is safe to use from multiple goroutines.
+type nickClaims struct {
+	store *store.Store // nil if claims aren't saved
+
+	mu    sync.Mutex
+	nicks map[string]string // from Discord user ID
+}
+
+func newNickClaims(s *store.Store) *nickClaims {
+	c := &nickClaims{store: s, nicks: make(map[string]string)}
+	if s != nil {
+		if _, err := s.Get(nickClaimsKey, &c.nicks); err != nil {
+			log.WithError(err).Errorln("could not read nick claims, ignoring them")
+		}
+	}
+	return c
+}
+
+// Get returns the nick claimed by a Discord user
+func (c *nickClaims) Get(userID string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	nick, ok := c.nicks[userID]
+	return nick, ok
+}
+
+// Check returns an error if a Discord user can't claim a nick,
+// because someone else has claimed it
+func (c *nickClaims) Check(userID, nick string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.check(userID, nick)
+}
+
+func (c *nickClaims) check(userID, nick string) error {
+	if c.store == nil {
+		return errors.New("nicks can't be claimed without a state_file")
+	}
+
+	for id, claimed := range c.nicks {
+		if nick != "" && id != userID && strings.EqualFold(claimed, nick) {
+			return fmt.Errorf("%s has already been claimed by someone else", nick)
+		}
+	}
+	return nil
+}
+
+// Set claims a nick for a Discord user, or removes their claim if nick is
+// blank. Returns an error if someone else has claimed it.
+func (c *nickClaims) Set(userID, nick string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.check(userID, nick); err != nil {
+		return err
+	}
+
+	nicks := make(map[string]string, len(c.nicks)+1)
+	for id, claimed := range c.nicks {
+		nicks[id] = claimed
+	}
+
+	if nick == "" {
+		delete(nicks, userID)
+	} else {
+		nicks[userID] = nick
+	}
+
+	if err := c.store.Set(nickClaimsKey, nicks); err != nil {
+		return errors.Wrap(err, "could not save nick")
+	}
+	c.nicks = nicks
+	return nil
+}
+
+// validateNickClaim returns an error if nick can't be claimed, because it
+// isn't a valid IRC nick, or is too long once the suffix has been added
+func validateNickClaim(nick, suffix string, maxLength int) error {
+	if nick == "" {
+		return errors.New("nick can't be blank")
+	}
+
+	if nick[0] == '-' || ircnick.IsDigit(nick[0]) {
+		return errors.New("nick can't start with a digit or -")
+	}
+
+	for i := 0; i < len(nick); i++ {
+		if c := nick[i]; !ircnick.IsNickChar(c) || ircnick.IsFakeNickChar(c) {
+			return fmt.Errorf("nick can't contain %q", string(c))
+		}
+	}
+
+	if maxLength > 0 && len(nick+suffix) > maxLength {
+		return fmt.Errorf("nick can be at most %d characters long", maxLength-len(suffix))
+	}
+
+	return nil
+}
+
+// maxNickLength is the longest nick puppets can have, which is
+// the lower of the server's NICKLEN and max_nick_length
+func (m *IRCManager) maxNickLength() int {
+	length := m.bridge.Config.MaxNickLength
+	if serverLength := m.bridge.ircListener.NickLength(); serverLength > 0 && (length <= 0 || serverLength < length) {
+		length = serverLength
+	}
+	return length
+}
+
+// checkNickClaim returns an error if a Discord user can't claim an IRC
+// nick, because it's invalid or in use. It must be called from the loop.
+func (m *IRCManager) checkNickClaim(userID, nick string) error {
+	if nick != "" {
+		suffix := m.bridge.Config.Suffix
+		if err := validateNickClaim(nick, suffix, m.maxNickLength()); err != nil {
+			return err
+		}
+
+		full := nick + suffix
+		if strings.EqualFold(full, m.bridge.ircListener.GetNick()) {
+			return fmt.Errorf("%s is in use on IRC", full)
+		}
+
+		puppet, isPuppet := m.puppetNicks[full]
+		if isPuppet && puppet.discord.ID != userID {
+			return fmt.Errorf("%s is in use by someone else on Discord", full)
+		} else if !isPuppet && m.bridge.ircListener.DoesUserExist(full) {
+			return fmt.Errorf("%s is in use on IRC", full)
+		}
+	}
+
+	return m.nickClaims.Check(userID, nick)
+}
+
+// claimedNickname returns the nick a Discord user has claimed, with the
+// suffix, if they have claimed one and it's available
+func (m *IRCManager) claimedNickname(userID string) (string, bool) {
+	claimed, ok := m.nickClaims.Get(userID)
+	if !ok {
+		return "", false
+	}
+
+	nick := claimed + m.bridge.Config.Suffix
+	if con, ok := m.puppetNicks[nick]; ok && con.discord.ID == userID {
+		return nick, true
+	}
+	if m.bridge.ircListener.DoesUserExist(nick) {
+		log.WithField("nick", nick).Warnln("claimed nick is in use on IRC, using the generated nick instead")
+		return "", false
+	}
+	return nick, true
+}
+
+// onISupport records the server's NICKLEN from RPL_ISUPPORT
+func (i *ircListener) onISupport(e *irc.Event) {
+	for _, arg := range e.Arguments {
+		if !strings.HasPrefix(arg, "NICKLEN=") {
+			continue
+		}
+
+		if length, err := strconv.Atoi(strings.TrimPrefix(arg, "NICKLEN=")); err == nil {
+			atomic.StoreInt32(&i.nickLength, int32(length))
+		}
+	}
+}
+
+// NickLength returns the server's NICKLEN, or 0 if it isn't known
+func (i *ircListener) NickLength() int {
+	return int(atomic.LoadInt32(&i.nickLength))
+}
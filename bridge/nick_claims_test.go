@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateNickClaim(t *testing.T) {
+	tests := []struct {
+		nick      string
+		suffix    string
+		maxLength int
+		valid     bool
+	}{
+		{"qaisjp", "~d", 30, true},
+		{"q[a]is_jp`", "~d", 30, true},
+		{"", "~d", 30, false},
+		{"1qais", "~d", 30, false},
+		{"-qais", "~d", 30, false},
+		{"qais jp", "~d", 30, false},
+		{"qais!jp", "~d", 30, false},
+		{"qais~jp", "~d", 30, false},
+		{"qaisjp", "~d", 8, true},
+		{"qaisjp", "~d", 7, false},
+		{"a-very-long-nick-indeed", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		err := validateNickClaim(tt.nick, tt.suffix, tt.maxLength)
+		if tt.valid {
+			assert.NoError(t, err, tt.nick)
+		} else {
+			assert.Error(t, err, tt.nick)
+		}
+	}
+}
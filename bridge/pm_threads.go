@@ -0,0 +1,153 @@
+package bridge
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/qaisjp/go-discord-irc/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// pmThreadsKey is where PM threads are saved in the store
+const pmThreadsKey = "pm_threads"
+
+// pmThreadArchiveDuration is how many minutes a PM thread stays open
+// for without messages. Messages sent to it reopen it.
+const pmThreadArchiveDuration = 7 * 24 * 60
+
+// pmThread is a private Discord thread for a Discord user's
+// private messages with someone on IRC
+type pmThread struct {
+	UserID string `json:"user_id"` // the Discord user
+	Nick   string `json:"nick"`    // who they are talking to on IRC
+}
+
+// pmThreads are the threads in the pm_channel.
+//
+// It is safe to use from multiple goroutines.
+type pmThreads struct {
+	store *store.Store // nil if threads aren't saved
+
+	mu      sync.Mutex
+	threads map[string]pmThread // by thread ID
+}
+
+func newPMThreads(s *store.Store) *pmThreads {
+	t := &pmThreads{store: s, threads: make(map[string]pmThread)}
+	if s != nil {
+		if _, err := s.Get(pmThreadsKey, &t.threads); err != nil {
+			log.WithError(err).Errorln("could not read PM threads, new ones will be created")
+		}
+	}
+	return t
+}
+
+// Get returns who a thread is for
+func (t *pmThreads) Get(threadID string) (pmThread, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	thread, ok := t.threads[threadID]
+	return thread, ok
+}
+
+// Find returns the thread for a Discord user's conversation with an IRC nick
+func (t *pmThreads) Find(userID, nick string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, thread := range t.threads {
+		if thread.UserID == userID && strings.EqualFold(thread.Nick, nick) {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Set adds a thread, or removes it if thread is nil
+func (t *pmThreads) Set(threadID string, thread *pmThread) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if thread == nil {
+		delete(t.threads, threadID)
+	} else {
+		t.threads[threadID] = *thread
+	}
+
+	if t.store != nil {
+		if err := t.store.Set(pmThreadsKey, t.threads); err != nil {
+			log.WithError(err).Errorln("could not save PM threads")
+		}
+	}
+}
+
+// pmThread returns the thread for a Discord user's conversation with an
+// IRC nick, creating it if there isn't one
+func (d *discordBot) pmThread(userID, nick string) (string, error) {
+	if id, ok := d.pmThreads.Find(userID, nick); ok {
+		return id, nil
+	}
+
+	thread, err := d.Session.ThreadStartComplex(d.bridge.Config.PMChannel, &discordgo.ThreadStart{
+		Name:                fmt.Sprintf("%s@%s", nick, d.bridge.Config.Discriminator),
+		AutoArchiveDuration: pmThreadArchiveDuration,
+		Type:                discordgo.ChannelTypeGuildPrivateThread,
+		Invitable:           false,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "could not create PM thread, does the bot have Create Private Threads?")
+	}
+
+	if err := d.Session.ThreadMemberAdd(thread.ID, userID); err != nil {
+		return "", errors.Wrap(err, "could not add user to PM thread")
+	}
+
+	d.pmThreads.Set(thread.ID, &pmThread{UserID: userID, Nick: nick})
+
+	_, err = d.Session.ChannelMessageSend(thread.ID, fmt.Sprintf("Private messages with `%s` on IRC. Reply in this thread to message them.", nick))
+	if err != nil {
+		log.WithError(err).Warnln("could not introduce PM thread")
+	}
+	return thread.ID, nil
+}
+
+// sendPMThread sends a private message from IRC to the Discord user's
+// thread for the sender, returning false if it could not be sent
+func (d *discordBot) sendPMThread(userID, nick, text string) bool {
+	content := fmt.Sprintf("`%s`: %s", nick, text)
+
+	// The thread may have been deleted, so try a new one if it's gone
+	for attempt := 0; attempt < 2; attempt++ {
+		threadID, err := d.pmThread(userID, nick)
+		if err != nil {
+			log.WithError(err).Warnln("could not get PM thread, sending PM to DMs instead")
+			return false
+		}
+
+		_, err = d.Session.ChannelMessageSend(threadID, content)
+		if err == nil {
+			return true
+		} else if !isUnknownChannel(err) {
+			log.WithError(err).Warnln("could not send to PM thread, sending PM to DMs instead")
+			return false
+		}
+		d.pmThreads.Set(threadID, nil)
+	}
+	return false
+}
+
+// isUnknownChannel returns whether an error from Discord is because
+// the channel doesn't exist
+func isUnknownChannel(err error) bool {
+	restErr, ok := err.(*discordgo.RESTError)
+	if !ok {
+		return false
+	}
+	if restErr.Message != nil && restErr.Message.Code == discordgo.ErrCodeUnknownChannel {
+		return true
+	}
+	return restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
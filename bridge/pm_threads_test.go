@@ -0,0 +1,54 @@
+package bridge
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPMThreads(t *testing.T) {
+	threads := newPMThreads(nil)
+	threads.Set("1", &pmThread{UserID: "alice", Nick: "Bob"})
+	threads.Set("2", &pmThread{UserID: "alice", Nick: "carol"})
+	threads.Set("3", &pmThread{UserID: "dave", Nick: "bob"})
+
+	id, ok := threads.Find("alice", "bob")
+	assert.True(t, ok)
+	assert.Equal(t, "1", id)
+
+	id, ok = threads.Find("dave", "BOB")
+	assert.True(t, ok)
+	assert.Equal(t, "3", id)
+
+	_, ok = threads.Find("dave", "carol")
+	assert.False(t, ok)
+
+	thread, ok := threads.Get("2")
+	assert.True(t, ok)
+	assert.Equal(t, pmThread{UserID: "alice", Nick: "carol"}, thread)
+
+	threads.Set("1", nil)
+	_, ok = threads.Find("alice", "bob")
+	assert.False(t, ok)
+	_, ok = threads.Get("1")
+	assert.False(t, ok)
+}
+
+func TestIsUnknownChannel(t *testing.T) {
+	unknown := &discordgo.RESTError{
+		Response: &http.Response{StatusCode: http.StatusNotFound},
+		Message:  &discordgo.APIErrorMessage{Code: discordgo.ErrCodeUnknownChannel},
+	}
+	forbidden := &discordgo.RESTError{
+		Response: &http.Response{StatusCode: http.StatusForbidden},
+		Message:  &discordgo.APIErrorMessage{Code: discordgo.ErrCodeMissingAccess},
+	}
+
+	assert.True(t, isUnknownChannel(unknown))
+	assert.True(t, isUnknownChannel(&discordgo.RESTError{Response: &http.Response{StatusCode: http.StatusNotFound}}))
+	assert.False(t, isUnknownChannel(forbidden))
+	assert.False(t, isUnknownChannel(errors.New("timeout")))
+}
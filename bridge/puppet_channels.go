@@ -0,0 +1,114 @@
+package bridge
+
+import (
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// canView returns whether a Discord user can see a channel,
+// which is false if their permissions aren't known
+func (d *discordBot) canView(userID, channelID string) bool {
+	perms, err := d.Session.State.UserChannelPermissions(userID, channelID)
+	return err == nil && perms&discordgo.PermissionViewChannel != 0
+}
+
+// channelChanges compares the mappings a puppet should be in with the IRC
+// channels it is in, returning the mappings to join and the channels to part
+func channelChanges(want []Mapping, channels []string, in func(channel string) bool) (join []Mapping, part []string) {
+	wanted := make(map[string]bool, len(want))
+	for _, mapping := range want {
+		if !wanted[strings.ToLower(mapping.IRCChannel)] && !in(mapping.IRCChannel) {
+			join = append(join, mapping)
+		}
+		wanted[strings.ToLower(mapping.IRCChannel)] = true
+	}
+
+	for _, channel := range channels {
+		if !wanted[strings.ToLower(channel)] && in(channel) {
+			part = append(part, channel)
+		}
+	}
+	return join, part
+}
+
+// channelMapping returns a mapping the puppet should be in an IRC channel
+// for, or false if its Discord user can't see a channel bridged to it
+func (i *ircConnection) channelMapping(channel string) (Mapping, bool) {
+	for _, mapping := range i.manager.RequestChannels(i.discord.ID) {
+		if strings.EqualFold(mapping.IRCChannel, channel) {
+			return mapping, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// updateChannels makes the puppet join the channels its Discord user has
+// been given access to, and part the ones they can no longer see
+func (i *ircConnection) updateChannels() {
+	listener := i.manager.bridge.ircListener
+	nick := i.GetNick()
+
+	join, part := channelChanges(i.channels(), i.manager.bridge.ircChannels(), func(channel string) bool {
+		if ch, ok := listener.GetChannel(channel); ok {
+			_, ok = ch.GetUser(nick)
+			return ok
+		}
+		return false
+	})
+
+	if len(part) > 0 {
+		i.SendRaw("PART " + strings.Join(part, ","))
+	}
+	if len(join) > 0 {
+		i.SendRaw(i.manager.bridge.GetJoinCommand(join))
+		i.requestModes(join)
+	}
+}
+
+// updateChannels checks which channels every puppet should be in
+func (m *IRCManager) updateChannels() {
+	for _, con := range m.ircConnections {
+		con.updateChannels()
+	}
+}
+
+// Roles and permission overwrites change who can see which channels
+
+func (d *discordBot) onGuildRoleUpdate(s *discordgo.Session, r *discordgo.GuildRoleUpdate) {
+	d.bridge.updateChannelsChan <- struct{}{}
+}
+
+func (d *discordBot) onGuildRoleDelete(s *discordgo.Session, r *discordgo.GuildRoleDelete) {
+	d.bridge.updateChannelsChan <- struct{}{}
+}
+
+func (d *discordBot) onChannelPermissionsUpdate(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+	// Channels are also updated for other reasons, like their topic
+	if c.BeforeUpdate != nil && sameOverwrites(c.BeforeUpdate.PermissionOverwrites, c.PermissionOverwrites) {
+		return
+	}
+
+	if len(d.bridge.GetMappingsByDiscord(c.ID)) > 0 {
+		d.bridge.updateChannelsChan <- struct{}{}
+	}
+}
+
+// sameOverwrites returns whether two lists of permission overwrites are
+// the same, in any order
+func sameOverwrites(a, b []*discordgo.PermissionOverwrite) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	overwrites := make(map[string]discordgo.PermissionOverwrite, len(a))
+	for _, o := range a {
+		overwrites[o.ID] = *o
+	}
+	for _, o := range b {
+		if before, ok := overwrites[o.ID]; !ok || before != *o {
+			return false
+		}
+	}
+	return true
+}
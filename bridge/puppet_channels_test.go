@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChannelChanges(t *testing.T) {
+	all := []string{"#a", "#b", "#c"}
+
+	tests := []struct {
+		name string
+		want []Mapping
+		in   []string
+		join []string
+		part []string
+	}{
+		{"nothing to do", []Mapping{{IRCChannel: "#a"}}, []string{"#a"}, nil, nil},
+		{"joins", []Mapping{{IRCChannel: "#a"}, {IRCChannel: "#b"}}, []string{"#a"}, []string{"#b"}, nil},
+		{"parts", []Mapping{{IRCChannel: "#a"}}, []string{"#a", "#c"}, nil, []string{"#c"}},
+		{"both", []Mapping{{IRCChannel: "#B"}, {IRCChannel: "#b"}}, []string{"#a"}, []string{"#B"}, []string{"#a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			join, part := channelChanges(tt.want, all, func(channel string) bool {
+				for _, c := range tt.in {
+					if strings.EqualFold(c, channel) {
+						return true
+					}
+				}
+				return false
+			})
+
+			var joined []string
+			for _, mapping := range join {
+				joined = append(joined, mapping.IRCChannel)
+			}
+			assert.Equal(t, tt.join, joined)
+			assert.Equal(t, tt.part, part)
+		})
+	}
+}
+
+func TestCanView(t *testing.T) {
+	state := discordgo.NewState()
+	assert.NoError(t, state.GuildAdd(&discordgo.Guild{
+		ID:      "g",
+		OwnerID: "owner",
+		Roles: []*discordgo.Role{
+			{ID: "g", Permissions: discordgo.PermissionViewChannel},
+			{ID: "staff"},
+		},
+		Channels: []*discordgo.Channel{
+			{ID: "general", GuildID: "g"},
+			{ID: "staff", GuildID: "g", PermissionOverwrites: []*discordgo.PermissionOverwrite{
+				{ID: "g", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel},
+				{ID: "staff", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel},
+			}},
+		},
+		Members: []*discordgo.Member{
+			{GuildID: "g", User: &discordgo.User{ID: "alice"}, Roles: []string{"staff"}},
+			{GuildID: "g", User: &discordgo.User{ID: "bob"}},
+		},
+	}))
+	d := &discordBot{Session: &discordgo.Session{State: state}}
+
+	assert.True(t, d.canView("alice", "general"))
+	assert.True(t, d.canView("alice", "staff"))
+	assert.True(t, d.canView("bob", "general"))
+	assert.False(t, d.canView("bob", "staff"))
+	assert.False(t, d.canView("carol", "general"), "unknown members can't see anything")
+}
+
+func TestSameOverwrites(t *testing.T) {
+	staff := &discordgo.PermissionOverwrite{ID: "staff", Type: discordgo.PermissionOverwriteTypeRole, Allow: discordgo.PermissionViewChannel}
+	everyone := &discordgo.PermissionOverwrite{ID: "g", Type: discordgo.PermissionOverwriteTypeRole, Deny: discordgo.PermissionViewChannel}
+	allowed := &discordgo.PermissionOverwrite{ID: "g", Type: discordgo.PermissionOverwriteTypeRole}
+
+	assert.True(t, sameOverwrites(nil, nil))
+	assert.True(t, sameOverwrites([]*discordgo.PermissionOverwrite{staff, everyone}, []*discordgo.PermissionOverwrite{everyone, staff}))
+	assert.False(t, sameOverwrites([]*discordgo.PermissionOverwrite{staff, everyone}, []*discordgo.PermissionOverwrite{staff, allowed}))
+	assert.False(t, sameOverwrites([]*discordgo.PermissionOverwrite{staff}, nil))
+}
@@ -0,0 +1,160 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// A DiscordReaction is a reaction added to, or removed from, a Discord message
+type DiscordReaction struct {
+	*discordgo.MessageReaction
+	Added bool
+}
+
+// reactionBatcher collects reactions to each message for a while, so that
+// they can be summarised on IRC in one line instead of one line each.
+// A message bridged to several IRC channels is summarised in each of them.
+//
+// It is safe to use from multiple goroutines.
+type reactionBatcher struct {
+	mu      sync.Mutex
+	pending map[reactionKey]struct{}
+
+	// flush is called once the window for a message is over
+	flush func(channelID, messageID, ircChannel string)
+}
+
+// A reactionKey is a Discord message, and an IRC channel it is summarised in
+type reactionKey struct {
+	messageID  string
+	ircChannel string // lower case
+}
+
+func newReactionBatcher(flush func(channelID, messageID, ircChannel string)) *reactionBatcher {
+	return &reactionBatcher{
+		pending: make(map[reactionKey]struct{}),
+		flush:   flush,
+	}
+}
+
+// Add records a reaction change, the summary is flushed after the window
+// if this is the first change to the message since the last flush
+func (r *reactionBatcher) Add(reaction DiscordReaction, ircChannel string, window time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := reactionKey{reaction.MessageID, strings.ToLower(ircChannel)}
+	if _, ok := r.pending[key]; ok {
+		return
+	}
+	r.pending[key] = struct{}{}
+
+	channelID, messageID := reaction.ChannelID, reaction.MessageID
+	time.AfterFunc(window, func() {
+		r.mu.Lock()
+		delete(r.pending, key)
+		r.mu.Unlock()
+
+		r.flush(channelID, messageID, ircChannel)
+	})
+}
+
+// flushReactions sends a summary of the reactions to a message to IRC
+func (d *discordBot) flushReactions(channelID, messageID, ircChannel string) {
+	// Fetch the message again for the latest reaction counts
+	msg, err := d.Session.ChannelMessage(channelID, messageID)
+	if err != nil {
+		log.WithError(err).WithField("message", messageID).Errorln("could not fetch message to summarise reactions")
+		return
+	}
+
+	d.bridge.ircListener.Notice(ircChannel, d.reactionSummary(msg))
+}
+
+// reactionSummary renders the reactions to a message, like
+// `reactions to <bob> "ship it": 👍×5 🎉×2`
+func (d *discordBot) reactionSummary(msg *discordgo.Message) string {
+	// HACK: this is before d.ParseText so that the existing <@uid> translation logic can be used
+	target := &discordgo.Message{
+		Content:      "<" + userToMention(msg.Author) + ">",
+		Mentions:     msg.Mentions,
+		MentionRoles: msg.MentionRoles,
+	}
+	if !msg.Author.Bot {
+		// HACK: theoretically could already be there, thereotically not a big problem
+		target.Mentions = append(target.Mentions, msg.Author)
+	}
+	if msg.Content != "" {
+		// Truncate messages to just 40 characters so reactions to long messages
+		// don't pollute the IRC log. Similarly, replace newlines with spaces
+		// so that any reactions to messages with a newline within the first 40
+		// characters don't cause multiple IRC messages to be sent.
+		target.Content += fmt.Sprintf(` "%s"`, strings.ReplaceAll(TruncateString(40, msg.Content), "\n", " "))
+	}
+
+	var counts []string
+	for _, reaction := range msg.Reactions {
+		counts = append(counts, fmt.Sprintf("%s×%d", reactionEmoji(reaction.Emoji), reaction.Count))
+	}
+
+	summary := "none"
+	if len(counts) > 0 {
+		summary = strings.Join(counts, " ")
+	}
+
+	return fmt.Sprintf("reactions to %s: %s", d.ParseText(target), summary)
+}
+
+func reactionEmoji(e *discordgo.Emoji) string {
+	if e.ID != "" {
+		// Custom emoji
+		return ":" + e.Name + ":"
+	}
+	return e.Name
+}
+
+// reactionTarget returns the IRC msgid of a Discord message, if known,
+// so that reactions to it can be sent as IRCv3 reactions
+func (m *IRCManager) reactionTarget(messageID, ircChannel string) string {
+	for _, msg := range m.relayed.Get(messageID) {
+		if strings.EqualFold(msg.IRCChannel, ircChannel) && len(msg.IRCMsgIDs) > 0 {
+			return msg.IRCMsgIDs[0]
+		}
+	}
+
+	if msg, ok := m.bridge.webhookHistory.Get(messageID); ok && strings.EqualFold(msg.IRCChannel, ircChannel) {
+		return msg.IRCMsgID
+	}
+
+	return ""
+}
+
+// HandleReaction relays a reaction from Discord to IRC. If the reacting
+// user's puppet can send IRCv3 reactions to the message it does so,
+// otherwise the reaction is included in a summary line.
+func (m *IRCManager) HandleReaction(reaction DiscordReaction, ircChannel string) {
+	if m.ircIgnoredDiscord(ircChannel, reaction.UserID) {
+		return
+	}
+
+	if con, ok := m.ircConnections[reaction.UserID]; ok {
+		if msgid := m.reactionTarget(reaction.MessageID, ircChannel); msgid != "" {
+			if ok, err := m.varys.HasCapability(con.discord.ID, "message-tags"); err == nil && ok {
+				tag := "+draft/react"
+				if !reaction.Added {
+					tag = "+draft/unreact"
+				}
+
+				con.SendRaw(fmt.Sprintf("@%s=%s;+draft/reply=%s TAGMSG %s", tag, reactionEmoji(&reaction.Emoji), msgid, ircChannel))
+				return
+			}
+		}
+	}
+
+	m.bridge.discord.reactions.Add(reaction, ircChannel, m.bridge.Config.ReactionWindow)
+}
@@ -0,0 +1,37 @@
+package bridge
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReactionBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var flushed []string
+	done := make(chan struct{}, 4)
+	r := newReactionBatcher(func(channelID, messageID, ircChannel string) {
+		mu.Lock()
+		flushed = append(flushed, messageID+" "+ircChannel)
+		mu.Unlock()
+		done <- struct{}{}
+	})
+
+	reaction := func(messageID string) DiscordReaction {
+		return DiscordReaction{MessageReaction: &discordgo.MessageReaction{ChannelID: "chan", MessageID: messageID}, Added: true}
+	}
+	r.Add(reaction("1"), "#a", 20*time.Millisecond)
+	r.Add(reaction("1"), "#A", 20*time.Millisecond)
+	r.Add(reaction("1"), "#b", 20*time.Millisecond)
+	r.Add(reaction("2"), "#a", 20*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	assert.ElementsMatch(t, []string{"1 #a", "1 #b", "2 #a"}, flushed)
+}
@@ -0,0 +1,334 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+)
+
+// relayHistoryLimit is the number of relayed Discord messages to remember
+const relayHistoryLimit = 1000
+
+// A relayedMessage is a Discord message that has been sent to an IRC channel
+type relayedMessage struct {
+	DiscordID  string
+	AuthorID   string // Discord user ID of the author
+	UserID     string // Discord user ID of the puppet that sent it, blank if it was the listener
+	Nick       string // who the message appears to be from on IRC
+	IRCChannel string
+
+	DiscordChannel string // the Discord channel it was sent in
+
+	// IRCv3 msgids of each line, if the server echoes our messages back
+	IRCMsgIDs []string
+}
+
+// relayHistory remembers recently relayed Discord messages, so that later
+// changes to them on Discord (such as deletions) can be applied on IRC.
+//
+// It is safe to use from multiple goroutines.
+type relayHistory struct {
+	mu       sync.Mutex
+	messages map[string][]*relayedMessage // from Discord message ID
+	order    []string                     // Discord message IDs, oldest first
+
+	// Lines sent but not yet echoed back, by sender UID and channel
+	pending map[string][]pendingLine
+
+	// The latest message by each nick, by channel and nick
+	latest map[string]*relayedMessage
+}
+
+func newRelayHistory() *relayHistory {
+	return &relayHistory{
+		messages: make(map[string][]*relayedMessage),
+		pending:  make(map[string][]pendingLine),
+		latest:   make(map[string]*relayedMessage),
+	}
+}
+
+// A pendingLine is a line of a relayed message that hasn't been echoed yet
+type pendingLine struct {
+	msg  *relayedMessage
+	text string
+}
+
+func pendingKey(uid, channel string) string {
+	return uid + " " + strings.ToLower(channel)
+}
+
+func latestKey(channel, nick string) string {
+	return strings.ToLower(channel) + " " + strings.ToLower(nick)
+}
+
+// Sent records that a line of a Discord message, with the given text,
+// has been written to an IRC channel
+func (h *relayHistory) Sent(line relayedMessage, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var msg *relayedMessage
+	for _, m := range h.messages[line.DiscordID] {
+		if strings.EqualFold(m.IRCChannel, line.IRCChannel) {
+			msg = m
+			break
+		}
+	}
+
+	if msg == nil {
+		if _, ok := h.messages[line.DiscordID]; !ok {
+			h.order = append(h.order, line.DiscordID)
+		}
+
+		msg = &line
+		msg.IRCMsgIDs = nil
+		h.messages[msg.DiscordID] = append(h.messages[msg.DiscordID], msg)
+		h.latest[latestKey(msg.IRCChannel, msg.Nick)] = msg
+
+		// Forget the oldest messages
+		for len(h.order) > relayHistoryLimit {
+			for _, m := range h.messages[h.order[0]] {
+				key := latestKey(m.IRCChannel, m.Nick)
+				if h.latest[key] == m {
+					delete(h.latest, key)
+				}
+			}
+			delete(h.messages, h.order[0])
+			h.order = h.order[1:]
+		}
+	}
+
+	key := pendingKey(msg.UserID, msg.IRCChannel)
+	pending := append(h.pending[key], pendingLine{msg, text})
+	if len(pending) > relayHistoryLimit {
+		pending = pending[1:]
+	}
+	h.pending[key] = pending
+}
+
+// Echoed records the msgid of our own message, echoed back by the server.
+// It is matched up with the oldest line with the same text sent by that
+// connection to that channel. Lines are echoed in the order they are
+// written, so older lines that weren't echoed were rejected, and are
+// forgotten.
+func (h *relayHistory) Echoed(uid, channel, text, msgid string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := pendingKey(uid, channel)
+	pending := h.pending[key]
+	for i, line := range pending {
+		if line.text != text {
+			continue
+		}
+
+		if i == len(pending)-1 {
+			delete(h.pending, key)
+		} else {
+			h.pending[key] = pending[i+1:]
+		}
+
+		if msgid != "" {
+			line.msg.IRCMsgIDs = append(line.msg.IRCMsgIDs, msgid)
+		}
+		return
+	}
+}
+
+// Get returns where a Discord message was relayed to
+func (h *relayHistory) Get(discordID string) []relayedMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var msgs []relayedMessage
+	for _, msg := range h.messages[discordID] {
+		msgs = append(msgs, *msg)
+	}
+	return msgs
+}
+
+// Latest returns the latest message relayed from a nick in a channel
+func (h *relayHistory) Latest(channel, nick string) (relayedMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msg, ok := h.latest[latestKey(channel, nick)]
+	if !ok {
+		return relayedMessage{}, false
+	}
+	return *msg, true
+}
+
+// Remove forgets a Discord message, returning where it was relayed to
+func (h *relayHistory) Remove(discordID string) []*relayedMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs, ok := h.messages[discordID]
+	if !ok {
+		return nil
+	}
+
+	for _, m := range msgs {
+		key := latestKey(m.IRCChannel, m.Nick)
+		if h.latest[key] == m {
+			delete(h.latest, key)
+		}
+	}
+	delete(h.messages, discordID)
+	for i, id := range h.order {
+		if id == discordID {
+			h.order = append(h.order[:i], h.order[i+1:]...)
+			break
+		}
+	}
+
+	return msgs
+}
+
+// relayedText remembers the text last relayed for recent Discord messages,
+// so that edits can be compared against what IRC has already seen.
+//
+// It is safe to use from multiple goroutines.
+type relayedText struct {
+	mu    sync.Mutex
+	text  map[string]string // from Discord message ID
+	order []string          // Discord message IDs, oldest first
+}
+
+func newRelayedText() *relayedText {
+	return &relayedText{text: make(map[string]string)}
+}
+
+// Swap stores the text relayed for a message, returning the previous text
+func (t *relayedText) Swap(discordID, text string) (previous string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	previous, ok = t.text[discordID]
+	t.text[discordID] = text
+	if ok {
+		return
+	}
+
+	t.order = append(t.order, discordID)
+	for len(t.order) > relayHistoryLimit {
+		delete(t.text, t.order[0])
+		t.order = t.order[1:]
+	}
+	return
+}
+
+// webhookHistoryLimit is the number of messages remembered per IRC nick, IRC channel and Discord channel
+const webhookHistoryLimit = 10
+
+// webhookHistoryNicks is the number of nicks (by IRC and Discord channel) to remember messages for
+const webhookHistoryNicks = 1000
+
+// A webhookMessage is a message from IRC, sent to Discord via a webhook
+type webhookMessage struct {
+	ID          string // Discord message ID
+	IRCChannel  string // the IRC channel it came from
+	IRCText     string // the message as it was sent on IRC
+	IsAction    bool
+	ReplyHeader string // if the message was converted into a reply
+	IRCMsgID    string // IRCv3 msgid of the message on IRC, if the server sent one
+}
+
+// webhookHistory remembers recent webhook messages for each IRC nick
+// in each Discord channel, so that IRC users can correct them. Nicks are
+// kept apart by IRC channel, as several can be bridged to one Discord channel.
+//
+// It is safe to use from multiple goroutines.
+type webhookHistory struct {
+	mu       sync.Mutex
+	messages map[string][]*webhookMessage // by Discord channel, IRC channel and nick, oldest first
+	byID     map[string]*webhookMessage   // by Discord message ID
+	order    []string                     // keys of messages, least recently added to first
+}
+
+func newWebhookHistory() *webhookHistory {
+	return &webhookHistory{
+		messages: make(map[string][]*webhookMessage),
+		byID:     make(map[string]*webhookMessage),
+	}
+}
+
+func webhookKey(channelID, ircChannel, nick string) string {
+	return channelID + " " + strings.ToLower(ircChannel) + " " + strings.ToLower(nick)
+}
+
+// Add remembers a message sent by an IRC nick, in msg.IRCChannel, to a Discord channel
+func (h *webhookHistory) Add(channelID, nick string, msg webhookMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	key := webhookKey(channelID, msg.IRCChannel, nick)
+	msgs, ok := h.messages[key]
+	if ok {
+		for i, k := range h.order {
+			if k == key {
+				h.order = append(h.order[:i], h.order[i+1:]...)
+				break
+			}
+		}
+	}
+	h.order = append(h.order, key)
+
+	msgs = append(msgs, &msg)
+	if len(msgs) > webhookHistoryLimit {
+		delete(h.byID, msgs[0].ID)
+		msgs = msgs[1:]
+	}
+	h.messages[key] = msgs
+	h.byID[msg.ID] = &msg
+
+	// Forget the nicks that haven't spoken for the longest
+	for len(h.order) > webhookHistoryNicks {
+		for _, m := range h.messages[h.order[0]] {
+			delete(h.byID, m.ID)
+		}
+		delete(h.messages, h.order[0])
+		h.order = h.order[1:]
+	}
+}
+
+// Get returns a webhook message by its Discord message ID
+func (h *webhookHistory) Get(discordID string) (webhookMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msg, ok := h.byID[discordID]
+	if !ok {
+		return webhookMessage{}, false
+	}
+	return *msg, true
+}
+
+// Latest returns the latest message sent by an IRC nick in an IRC channel
+// to a Discord channel
+func (h *webhookHistory) Latest(channelID, ircChannel, nick string) (webhookMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := h.messages[webhookKey(channelID, ircChannel, nick)]
+	if len(msgs) == 0 {
+		return webhookMessage{}, false
+	}
+	return *msgs[len(msgs)-1], true
+}
+
+// Substitute applies a substitution to the latest message it matches, of
+// those sent by an IRC nick in an IRC channel, returning the corrected message
+func (h *webhookHistory) Substitute(channelID, ircChannel, nick string, sub *substitution) (webhookMessage, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := h.messages[webhookKey(channelID, ircChannel, nick)]
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if text, ok := sub.Apply(msgs[i].IRCText); ok {
+			msgs[i].IRCText = text
+			return *msgs[i], true
+		}
+	}
+	return webhookMessage{}, false
+}
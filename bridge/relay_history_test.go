@@ -0,0 +1,134 @@
+package bridge
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelayHistoryEcho(t *testing.T) {
+	h := newRelayHistory()
+
+	// Two lines from one message, then a message from the listener
+	bob := relayedMessage{DiscordID: "1", AuthorID: "10", UserID: "10", Nick: "bob~d", IRCChannel: "#chan"}
+	h.Sent(bob, "one")
+	h.Sent(bob, "two")
+	h.Sent(relayedMessage{DiscordID: "2", AuthorID: "20", Nick: "alice", IRCChannel: "#chan"}, "<alice> hi")
+
+	h.Echoed("10", "#CHAN", "one", "a")
+	h.Echoed("", "#chan", "<alice> hi", "c")
+	h.Echoed("10", "#chan", "two", "b")
+	h.Echoed("10", "#chan", "two", "unknown")
+
+	latest, ok := h.Latest("#chan", "BOB~d")
+	if assert.True(t, ok) {
+		assert.Equal(t, "1", latest.DiscordID)
+	}
+
+	msgs := h.Remove("1")
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "bob~d", msgs[0].Nick)
+		assert.Equal(t, []string{"a", "b"}, msgs[0].IRCMsgIDs)
+	}
+	assert.Empty(t, h.Remove("1"))
+
+	_, ok = h.Latest("#chan", "bob~d")
+	assert.False(t, ok)
+
+	msgs = h.Remove("2")
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, []string{"c"}, msgs[0].IRCMsgIDs)
+	}
+}
+
+func TestRelayHistoryRejectedLine(t *testing.T) {
+	h := newRelayHistory()
+
+	// The first message is rejected by the server, so is never echoed
+	h.Sent(relayedMessage{DiscordID: "1", UserID: "10", Nick: "bob~d", IRCChannel: "#chan"}, "rejected")
+	h.Sent(relayedMessage{DiscordID: "2", UserID: "10", Nick: "bob~d", IRCChannel: "#chan"}, "hello")
+	h.Sent(relayedMessage{DiscordID: "3", UserID: "10", Nick: "bob~d", IRCChannel: "#chan"}, "rejected")
+
+	h.Echoed("10", "#chan", "hello", "a")
+	h.Echoed("10", "#chan", "rejected", "b")
+
+	assert.Empty(t, h.Get("1")[0].IRCMsgIDs)
+	assert.Equal(t, []string{"a"}, h.Get("2")[0].IRCMsgIDs)
+	assert.Equal(t, []string{"b"}, h.Get("3")[0].IRCMsgIDs)
+	assert.Empty(t, h.pending)
+}
+
+func TestRelayHistoryLimit(t *testing.T) {
+	h := newRelayHistory()
+	for i := 0; i <= relayHistoryLimit; i++ {
+		h.Sent(relayedMessage{DiscordID: strconv.Itoa(i), Nick: "nick", IRCChannel: "#chan"}, "text")
+	}
+
+	assert.Len(t, h.messages, relayHistoryLimit)
+	assert.Len(t, h.order, relayHistoryLimit)
+}
+
+func TestWebhookHistoryGet(t *testing.T) {
+	h := newWebhookHistory()
+	for i := 0; i <= webhookHistoryLimit; i++ {
+		h.Add("chan", "bob", webhookMessage{ID: strconv.Itoa(i), IRCChannel: "#a", IRCMsgID: "msg" + strconv.Itoa(i)})
+	}
+
+	_, ok := h.Get("0")
+	assert.False(t, ok)
+
+	msg, ok := h.Get("1")
+	if assert.True(t, ok) {
+		assert.Equal(t, "msg1", msg.IRCMsgID)
+	}
+	assert.Len(t, h.byID, webhookHistoryLimit)
+}
+
+func TestWebhookHistoryFanIn(t *testing.T) {
+	// #a and #b are both bridged to one Discord channel, with a bob in each
+	h := newWebhookHistory()
+	h.Add("chan", "bob", webhookMessage{ID: "1", IRCChannel: "#a", IRCText: "hello from a"})
+	h.Add("chan", "bob", webhookMessage{ID: "2", IRCChannel: "#b", IRCText: "hello from b"})
+
+	msg, ok := h.Latest("chan", "#A", "Bob")
+	if assert.True(t, ok) {
+		assert.Equal(t, "1", msg.ID)
+	}
+
+	sub, ok := parseSubstitution("s/hello/bye/")
+	assert.True(t, ok)
+	msg, ok = h.Substitute("chan", "#b", "bob", sub)
+	if assert.True(t, ok) {
+		assert.Equal(t, "2", msg.ID)
+		assert.Equal(t, "bye from b", msg.IRCText)
+	}
+
+	_, ok = h.Substitute("chan", "#c", "bob", sub)
+	assert.False(t, ok)
+}
+
+func TestWebhookHistoryNicks(t *testing.T) {
+	h := newWebhookHistory()
+	h.Add("chan", "first", webhookMessage{ID: "first", IRCChannel: "#a"})
+	for i := 0; i < webhookHistoryNicks; i++ {
+		h.Add("chan", strconv.Itoa(i), webhookMessage{ID: strconv.Itoa(i), IRCChannel: "#a"})
+
+		// first keeps speaking, so it isn't forgotten
+		if i == webhookHistoryNicks/2 {
+			h.Add("chan", "first", webhookMessage{ID: "again", IRCChannel: "#a"})
+		}
+	}
+
+	assert.Len(t, h.messages, webhookHistoryNicks)
+	assert.Len(t, h.order, webhookHistoryNicks)
+	_, ok := h.Get("0")
+	assert.False(t, ok)
+	_, ok = h.Latest("chan", "#a", "0")
+	assert.False(t, ok)
+
+	msg, ok := h.Latest("chan", "#a", "first")
+	if assert.True(t, ok) {
+		assert.Equal(t, "again", msg.ID)
+	}
+}
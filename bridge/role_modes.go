@@ -0,0 +1,256 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// prefixModes are channel modes shown as a prefix, highest first
+var prefixModes = []struct {
+	mode   byte
+	prefix string
+	// what ChanServ calls it, blank if ChanServ can't give it
+	chanServ string
+}{
+	{'q', "~", ""},
+	{'a', "&", ""},
+	{'o', "@", "OP"},
+	{'h', "%", "HALFOP"},
+	{'v', "+", "VOICE"},
+}
+
+// ircPrefix returns the prefix of the highest prefix mode in modes, like "@"
+func ircPrefix(modes string) string {
+	for _, m := range prefixModes {
+		if strings.IndexByte(modes, m.mode) != -1 {
+			return m.prefix
+		}
+	}
+	return ""
+}
+
+// userPrefix returns the prefix of an IRC user in a channel, if the
+// channel is in show_irc_prefixes
+func (i *ircListener) userPrefix(channel, nick string) string {
+	shown := false
+	for _, c := range i.bridge.Config.IRCPrefixChannels {
+		if strings.EqualFold(c, channel) {
+			shown = true
+			break
+		}
+	}
+	if !shown {
+		return ""
+	}
+
+	ch, ok := i.GetChannel(channel)
+	if !ok {
+		return ""
+	}
+	if user, ok := ch.GetUser(nick); ok {
+		return ircPrefix(user.Mode)
+	}
+	return ""
+}
+
+// roleModes returns the channel modes given to holders of the roles,
+// by role_modes, highest first
+func roleModes(roles []string, table map[string]string) string {
+	var modes []byte
+	for _, role := range roles {
+		for _, m := range table[role] {
+			if m < 128 && strings.IndexByte(string(modes), byte(m)) == -1 {
+				modes = append(modes, byte(m))
+			}
+		}
+	}
+
+	rank := func(mode byte) int {
+		for i, m := range prefixModes {
+			if m.mode == mode {
+				return i
+			}
+		}
+		return len(prefixModes)
+	}
+	sort.Slice(modes, func(i, j int) bool {
+		return rank(modes[i]) < rank(modes[j])
+	})
+	return string(modes)
+}
+
+// modeRequests returns the commands sent to give a puppet modes in a
+// channel: the puppet asks ChanServ, or the listener uses MODE,
+// depending on role_modes_via
+func modeRequests(via, channel, nick, modes string) []string {
+	var commands []string
+	for i := 0; i < len(modes); i++ {
+		mode := modes[i]
+		if via == "mode" {
+			commands = append(commands, fmt.Sprintf("MODE %s +%c %s", channel, mode, nick))
+			continue
+		}
+
+		for _, m := range prefixModes {
+			if m.mode == mode && m.chanServ != "" {
+				commands = append(commands, fmt.Sprintf("PRIVMSG ChanServ :%s %s", m.chanServ, channel))
+			}
+		}
+	}
+	return commands
+}
+
+// requestModes asks for the channel modes the puppet's Discord roles
+// give them, in the channels of mappings it has joined
+func (i *ircConnection) requestModes(mappings []Mapping) {
+	b := i.manager.bridge
+	if len(b.Config.RoleModes) == 0 {
+		return
+	}
+
+	modes := roleModes(b.discord.memberRoles(i.discord.ID), b.Config.RoleModes)
+	if modes == "" {
+		return
+	}
+
+	nick := i.GetNick()
+	for _, mapping := range mappings {
+		// The listener gives the modes once the puppet has joined
+		if b.Config.RoleModesVia == "mode" {
+			b.ircListener.grants.Add(mapping.IRCChannel, nick, modes, time.Now())
+			continue
+		}
+
+		for _, command := range modeRequests(b.Config.RoleModesVia, mapping.IRCChannel, nick, modes) {
+			log.WithFields(log.Fields{
+				"nick":    nick,
+				"command": command,
+			}).Debugln("requesting channel mode for Discord role")
+			i.SendRaw(command)
+		}
+	}
+}
+
+// modeGrants are the modes the listener gives puppets when they next join
+// a channel, for role_modes_via "mode". Grants expire if the puppet
+// doesn't join in time, like when it's banned.
+//
+// It is safe to use from multiple goroutines.
+type modeGrants struct {
+	mu     sync.Mutex
+	grants map[string]modeGrant // by modeGrantKey
+}
+
+// A modeGrant is the modes to give a nick when it joins a channel
+type modeGrant struct {
+	nick    string
+	modes   string
+	expires time.Time
+}
+
+// modeGrantExpiry is how long a puppet has to join a channel to be given modes
+const modeGrantExpiry = time.Minute
+
+func newModeGrants() *modeGrants {
+	return &modeGrants{grants: make(map[string]modeGrant)}
+}
+
+func modeGrantKey(channel, nick string) string {
+	return strings.ToLower(channel + " " + nick)
+}
+
+// Add gives a nick modes when it next joins a channel
+func (g *modeGrants) Add(channel, nick, modes string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, grant := range g.grants {
+		if now.After(grant.expires) {
+			delete(g.grants, key)
+		}
+	}
+	g.grants[modeGrantKey(channel, nick)] = modeGrant{nick: nick, modes: modes, expires: now.Add(modeGrantExpiry)}
+}
+
+// Take returns and forgets the modes to give a nick that joined a channel
+func (g *modeGrants) Take(channel, nick string, now time.Time) string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	key := modeGrantKey(channel, nick)
+	grant, ok := g.grants[key]
+	delete(g.grants, key)
+	if !ok || now.After(grant.expires) {
+		return ""
+	}
+	return grant.modes
+}
+
+// Forget forgets the modes to give a nick, when it quits or changes nick
+func (g *modeGrants) Forget(nick string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for key, grant := range g.grants {
+		if strings.EqualFold(grant.nick, nick) {
+			delete(g.grants, key)
+		}
+	}
+}
+
+// onPuppetLeave forgets the modes to give a puppet that quit or changed nick
+func (i *ircListener) onPuppetLeave(e *irc.Event) {
+	i.grants.Forget(e.Nick)
+}
+
+// onPuppetPart forgets the modes to give a puppet that left a channel
+func (i *ircListener) onPuppetPart(e *irc.Event) {
+	if len(e.Arguments) == 0 {
+		return
+	}
+
+	nick := e.Nick
+	if e.Code == "KICK" {
+		if len(e.Arguments) < 2 {
+			return
+		}
+		nick = e.Arguments[1]
+	}
+	i.grants.Take(e.Arguments[0], nick, time.Now())
+}
+
+// onPuppetJoin gives a puppet that joined a channel its role_modes,
+// if the listener is an operator there
+func (i *ircListener) onPuppetJoin(e *irc.Event) {
+	if len(e.Arguments) < 1 {
+		return
+	}
+
+	channel := e.Arguments[0]
+	modes := i.grants.Take(channel, e.Nick, time.Now())
+	if modes == "" {
+		return
+	}
+
+	if !i.ops.Has(channel) {
+		log.WithFields(log.Fields{
+			"nick":    e.Nick,
+			"channel": channel,
+		}).Warnln("could not give puppet its role_modes, the listener isn't an operator")
+		return
+	}
+
+	for _, command := range modeRequests("mode", channel, e.Nick, modes) {
+		log.WithFields(log.Fields{
+			"nick":    e.Nick,
+			"command": command,
+		}).Debugln("giving channel mode for Discord role")
+		i.SendRaw(command)
+	}
+}
@@ -0,0 +1,89 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIRCPrefix(t *testing.T) {
+	tests := []struct {
+		modes    string
+		expected string
+	}{
+		{"", ""},
+		{"v", "+"},
+		{"ov", "@"},
+		{"vo", "@"},
+		{"hv", "%"},
+		{"qo", "~"},
+		{"i", ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, ircPrefix(tt.modes), tt.modes)
+	}
+}
+
+func TestRoleModes(t *testing.T) {
+	table := map[string]string{
+		"mods":    "o",
+		"helpers": "v",
+		"both":    "vo",
+	}
+
+	tests := []struct {
+		roles    []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"others"}, ""},
+		{[]string{"helpers"}, "v"},
+		{[]string{"helpers", "mods"}, "ov"},
+		{[]string{"both", "mods"}, "ov"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, roleModes(tt.roles, table), tt.roles)
+	}
+}
+
+func TestModeRequests(t *testing.T) {
+	assert.Equal(t, []string{
+		"PRIVMSG ChanServ :OP #chan",
+		"PRIVMSG ChanServ :VOICE #chan",
+	}, modeRequests("chanserv", "#chan", "alice~d", "ov"))
+
+	assert.Equal(t, []string{
+		"MODE #chan +o alice~d",
+		"MODE #chan +v alice~d",
+	}, modeRequests("mode", "#chan", "alice~d", "ov"))
+
+	assert.Empty(t, modeRequests("chanserv", "#chan", "alice~d", "q"))
+}
+
+func TestModeGrants(t *testing.T) {
+	now := time.Now()
+	grants := newModeGrants()
+	grants.Add("#Chan", "alice~d", "o", now)
+	grants.Add("#chan", "bob~d", "v", now)
+
+	assert.Equal(t, "o", grants.Take("#chan", "Alice~d", now))
+	assert.Empty(t, grants.Take("#chan", "alice~d", now), "modes are only given once")
+	assert.Empty(t, grants.Take("#other", "bob~d", now))
+	assert.Equal(t, "v", grants.Take("#chan", "bob~d", now))
+
+	// Grants are forgotten when the nick goes away
+	grants.Add("#chan", "carol~d", "o", now)
+	grants.Add("#other", "carol~d", "v", now)
+	grants.Forget("Carol~d")
+	assert.Empty(t, grants.grants)
+
+	// or when it doesn't join in time
+	grants.Add("#chan", "dave~d", "o", now)
+	assert.Empty(t, grants.Take("#chan", "dave~d", now.Add(2*modeGrantExpiry)))
+	grants.Add("#chan", "erin~d", "o", now)
+	grants.Add("#chan", "frank~d", "o", now.Add(2*modeGrantExpiry))
+	assert.Len(t, grants.grants, 1)
+}
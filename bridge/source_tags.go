@@ -0,0 +1,45 @@
+package bridge
+
+import (
+	"unicode/utf8"
+)
+
+// discordMaxUsernameLength is the longest name a webhook message can have
+const discordMaxUsernameLength = 80
+
+// sourceTag returns what to tag messages from a mapping's IRC channel with
+// on Discord, or "" if its Discord channel is only bridged to that channel.
+// The network is included if the Discord channel is bridged to several.
+func (b *Bridge) sourceTag(mapping Mapping) string {
+	sources, networks := 0, 0
+	for _, network := range b.allNetworks() {
+		if n := len(mappingsToDiscord(network.GetMappingsByDiscord(mapping.DiscordChannel))); n > 0 {
+			sources += n
+			networks++
+		}
+	}
+
+	switch {
+	case sources < 2:
+		return ""
+	case networks > 1:
+		return mapping.IRCChannel + "@" + b.Config.Discriminator
+	}
+	return mapping.IRCChannel
+}
+
+// withSourceTag adds a source tag to a webhook username, shortening the tag
+// if the username would be too long for Discord
+func withSourceTag(username, tag string) string {
+	suffix := " [" + tag + "]"
+	if utf8.RuneCountInString(username+suffix) <= discordMaxUsernameLength {
+		return username + suffix
+	}
+
+	tagRunes := []rune(tag)
+	keep := discordMaxUsernameLength - utf8.RuneCountInString(username) - utf8.RuneCountInString(" […]")
+	if keep <= 0 {
+		return username
+	}
+	return username + " [" + string(tagRunes[:keep]) + "…]"
+}
@@ -0,0 +1,44 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourceTag(t *testing.T) {
+	main := &Bridge{
+		Config: &Config{Discriminator: "libera"},
+		mappings: []Mapping{
+			{IRCChannel: "#a", DiscordChannel: "1"},
+			{IRCChannel: "#b", DiscordChannel: "1"},
+			{IRCChannel: "#c", DiscordChannel: "2"},
+			{IRCChannel: "#d", DiscordChannel: "3"},
+		},
+	}
+	oftc := &Bridge{
+		Config:   &Config{Discriminator: "oftc"},
+		mappings: []Mapping{{IRCChannel: "#d", DiscordChannel: "3"}},
+		primary:  main,
+	}
+	main.networks = []*Bridge{oftc}
+
+	assert.Equal(t, "#a", main.sourceTag(Mapping{IRCChannel: "#a", DiscordChannel: "1"}))
+	assert.Equal(t, "", main.sourceTag(Mapping{IRCChannel: "#c", DiscordChannel: "2"}))
+	assert.Equal(t, "#d@libera", main.sourceTag(Mapping{IRCChannel: "#d", DiscordChannel: "3"}))
+	assert.Equal(t, "#d@oftc", oftc.sourceTag(Mapping{IRCChannel: "#d", DiscordChannel: "3"}))
+}
+
+func TestWithSourceTag(t *testing.T) {
+	assert.Equal(t, "alice [#chan]", withSourceTag("alice", "#chan"))
+
+	long := withSourceTag("alice", "#"+strings.Repeat("x", 100))
+	assert.Equal(t, discordMaxUsernameLength, utf8.RuneCountInString(long))
+	assert.True(t, strings.HasPrefix(long, "alice [#xxx"))
+	assert.True(t, strings.HasSuffix(long, "…]"))
+
+	name := strings.Repeat("n", 79)
+	assert.Equal(t, name, withSourceTag(name, "#chan"))
+}
@@ -1,6 +1,8 @@
 package bridge
 
 import (
+	"strings"
+
 	"github.com/bwmarrin/discordgo"
 )
 
@@ -10,6 +12,23 @@ type DiscordMessage struct {
 	Content  string
 	IsAction bool
 	PmTarget string // target username, for PMs
+
+	// IRCChannels limits which of the bridged IRC channels the message
+	// is sent to, if it isn't empty
+	IRCChannels []string
+}
+
+// sendsTo returns whether the message should be sent to an IRC channel
+func (m *DiscordMessage) sendsTo(ircChannel string) bool {
+	if len(m.IRCChannels) == 0 {
+		return true
+	}
+	for _, channel := range m.IRCChannels {
+		if strings.EqualFold(channel, ircChannel) {
+			return true
+		}
+	}
+	return false
 }
 
 // IRCMessage is a chat message sent to Discord (from IRCListener)
@@ -18,6 +37,14 @@ type IRCMessage struct {
 	Username   string
 	Message    string
 	IsAction   bool
+
+	IRCText   string // the message as it was sent on IRC, before formatting
+	ReplyNick string // who the message is addressed to, if it may be a reply
+	IRCMsgID  string // IRCv3 msgid, if the server sent one
+	Prefix    string // shown before the username, like "@" for channel operators
+
+	// relayed is where a line sent by a puppet is remembered, once it is written
+	relayed *relayedMessage
 }
 
 // DiscordUser is information that IRC needs to know about a user
@@ -34,4 +61,5 @@ type DiscordUser struct {
 type Mapping struct {
 	DiscordChannel string
 	IRCChannel     string
+	Direction      Direction
 }
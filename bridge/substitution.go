@@ -0,0 +1,116 @@
+package bridge
+
+import (
+	"regexp"
+	"strings"
+)
+
+// A substitution is a sed-style correction, like "s/teh/the/"
+type substitution struct {
+	pattern     *regexp.Regexp
+	replacement string // in regexp.Expand syntax
+	global      bool
+}
+
+// parseSubstitution parses "s/pattern/replacement/flags", where the trailing
+// slash is optional and flags can be "g" (replace all) and "i" (ignore case).
+// Slashes can be escaped with a backslash.
+func parseSubstitution(text string) (*substitution, bool) {
+	if !strings.HasPrefix(text, "s/") {
+		return nil, false
+	}
+
+	// Split on unescaped slashes
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, c := range text[2:] {
+		switch {
+		case escaped:
+			if c != '/' {
+				part.WriteRune('\\')
+			}
+			part.WriteRune(c)
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '/':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(c)
+		}
+	}
+	if escaped {
+		part.WriteRune('\\')
+	}
+	parts = append(parts, part.String())
+
+	if len(parts) == 2 {
+		parts = append(parts, "")
+	}
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, false
+	}
+
+	pattern := parts[0]
+	sub := &substitution{replacement: sedReplacement(parts[1])}
+	for _, flag := range parts[2] {
+		switch flag {
+		case 'g':
+			sub.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return nil, false
+		}
+	}
+
+	var err error
+	if sub.pattern, err = regexp.Compile(pattern); err != nil {
+		return nil, false
+	}
+
+	return sub, true
+}
+
+// sedReplacement converts a sed replacement, where "&" is the whole
+// match and "\1" is a group, into regexp.Expand syntax
+func sedReplacement(sed string) string {
+	var b strings.Builder
+	escaped := false
+	for _, c := range sed {
+		switch {
+		case escaped && c >= '0' && c <= '9':
+			b.WriteString("${" + string(c) + "}")
+		case escaped || (c != '\\' && c != '&'):
+			if c == '$' {
+				b.WriteString("$$")
+			} else {
+				b.WriteRune(c)
+			}
+		case c == '&':
+			b.WriteString("${0}")
+		}
+		escaped = !escaped && c == '\\'
+	}
+	return b.String()
+}
+
+// Apply performs the substitution, returning false if nothing matched
+func (s *substitution) Apply(text string) (string, bool) {
+	if s.global {
+		if !s.pattern.MatchString(text) {
+			return "", false
+		}
+		return s.pattern.ReplaceAllString(text, s.replacement), true
+	}
+
+	loc := s.pattern.FindStringSubmatchIndex(text)
+	if loc == nil {
+		return "", false
+	}
+
+	replaced := s.pattern.ExpandString(nil, s.replacement, text, loc)
+	return text[:loc[0]] + string(replaced) + text[loc[1]:], true
+}
@@ -0,0 +1,46 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubstitution(t *testing.T) {
+	cases := []struct {
+		Message  string
+		Command  string
+		Input    string
+		Expected string
+	}{
+		{"simple", "s/teh/the/", "teh cat and teh dog", "the cat and teh dog"},
+		{"no trailing slash", "s/teh/the", "teh cat", "the cat"},
+		{"global", "s/teh/the/g", "teh cat and teh dog", "the cat and the dog"},
+		{"ignore case", "s/TEH/the/i", "Teh cat", "the cat"},
+		{"escaped slash", `s/a\/b/c/`, "a/b", "c"},
+		{"groups", `s/(\w+) (\w+)/\2 \1/`, "hello world", "world hello"},
+		{"whole match", "s/cat/&s/", "cat", "cats"},
+		{"literal dollar", "s/cost/$5/", "cost", "$5"},
+		{"delete", "s/very //", "very good", "good"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.Message, func(t *testing.T) {
+			sub, ok := parseSubstitution(c.Command)
+			if assert.True(t, ok) {
+				result, ok := sub.Apply(c.Input)
+				assert.True(t, ok)
+				assert.Equal(t, c.Expected, result)
+			}
+		})
+	}
+
+	for _, command := range []string{"s/", "s//x/", "s/a/b/x", "s/a/b/c/d", "s/(/x/", "hello s/a/b/"} {
+		_, ok := parseSubstitution(command)
+		assert.False(t, ok, command)
+	}
+
+	sub, _ := parseSubstitution("s/dog/cat/")
+	_, ok := sub.Apply("no match here")
+	assert.False(t, ok)
+}
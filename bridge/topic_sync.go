@@ -0,0 +1,176 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/bwmarrin/discordgo"
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// chanOpPrefixes are the NAMES prefixes of users who can set the topic
+const chanOpPrefixes = "~&@"
+
+// chanOps tracks the channels the listener is an operator in.
+//
+// It is safe to use from multiple goroutines.
+type chanOps struct {
+	mu       sync.Mutex
+	channels map[string]bool // by lowercase channel
+}
+
+func newChanOps() *chanOps {
+	return &chanOps{channels: make(map[string]bool)}
+}
+
+func (c *chanOps) Set(channel string, op bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.channels[strings.ToLower(channel)] = op
+}
+
+func (c *chanOps) Has(channel string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.channels[strings.ToLower(channel)]
+}
+
+// namesOp returns whether nick is in a RPL_NAMREPLY list of names,
+// and whether they are an operator
+func namesOp(nick, names string) (op bool, found bool) {
+	for _, name := range strings.Fields(names) {
+		trimmed := strings.TrimLeft(name, "~&@%+")
+		if strings.EqualFold(trimmed, nick) {
+			prefixes := name[:len(name)-len(trimmed)]
+			return strings.ContainsAny(prefixes, chanOpPrefixes), true
+		}
+	}
+	return false, false
+}
+
+// modeOp returns whether the arguments of a channel MODE give or take
+// operator from nick, like "#channel +ov nick other".
+func modeOp(nick string, args []string) (op bool, changed bool) {
+	if len(args) < 2 {
+		return false, false
+	}
+
+	params := args[2:]
+	adding := true
+	for _, mode := range args[1] {
+		switch mode {
+		case '+':
+			adding = true
+			continue
+		case '-':
+			adding = false
+			continue
+		}
+
+		// Modes with a parameter. The limit only has one when it is set.
+		if !strings.ContainsRune("beIkqaohv", mode) && !(mode == 'l' && adding) {
+			continue
+		}
+		if len(params) == 0 {
+			break
+		}
+
+		param := params[0]
+		params = params[1:]
+		if strings.ContainsRune("qao", mode) && strings.EqualFold(param, nick) {
+			op, changed = adding, true
+		}
+	}
+	return op, changed
+}
+
+// RPL_NAMREPLY: <client> <symbol> <channel> :[prefix]<nick>{ [prefix]<nick>}
+func (i *ircListener) onNamesReply(e *irc.Event) {
+	if len(e.Arguments) < 4 {
+		return
+	}
+
+	if op, ok := namesOp(i.GetNick(), e.Arguments[3]); ok {
+		i.ops.Set(e.Arguments[2], op)
+	}
+}
+
+func (i *ircListener) onMode(e *irc.Event) {
+	if len(e.Arguments) < 2 || !strings.HasPrefix(e.Arguments[0], "#") {
+		return
+	}
+
+	if op, ok := modeOp(i.GetNick(), e.Arguments); ok {
+		i.ops.Set(e.Arguments[0], op)
+	}
+}
+
+// topicSynced returns whether the topic of a mapped IRC channel is synced with Discord
+func (b *Bridge) topicSynced(ircChannel string) bool {
+	for _, channel := range b.Config.TopicSync {
+		if strings.EqualFold(channel, ircChannel) {
+			return true
+		}
+	}
+	return false
+}
+
+// syncTopicToDiscord sets the Discord channel's topic to an IRC topic
+func (i *ircListener) syncTopicToDiscord(channel, topic string) {
+	if !i.bridge.topicSynced(channel) {
+		return
+	}
+
+	// Discord can't clear topics by editing the channel
+	if topic == "" {
+		return
+	}
+
+	// Topic edits are heavily rate limited by Discord, so don't wait for them
+	for _, mapping := range mappingsToDiscord(i.bridge.GetMappingsByIRC(channel)) {
+		go i.bridge.discord.setTopic(mapping.DiscordChannel, i.bridge.Config.TopicPrefix+topic)
+	}
+}
+
+func (d *discordBot) setTopic(channelID, topic string) {
+	if channel, err := d.Session.State.Channel(channelID); err == nil && channel.Topic == topic {
+		return
+	}
+
+	if _, err := d.Session.ChannelEdit(channelID, &discordgo.ChannelEdit{Topic: topic}); err != nil {
+		log.WithError(err).WithField("channel", channelID).Warnln("could not set Discord channel topic, does the bot have Manage Channels?")
+	}
+}
+
+func (d *discordBot) onChannelUpdate(s *discordgo.Session, c *discordgo.ChannelUpdate) {
+	// Channels are also updated for other reasons, like their name or permissions
+	if c.BeforeUpdate != nil && c.BeforeUpdate.Topic == c.Topic {
+		return
+	}
+
+	// Topics we set from IRC have the prefix, so this stops them looping back,
+	// or going to other IRC channels bridged to the same Discord channel
+	prefix := d.bridge.Config.TopicPrefix
+	if prefix != "" && strings.HasPrefix(c.Topic, prefix) {
+		return
+	}
+	topic := strings.NewReplacer("\r", " ", "\n", " ").Replace(c.Topic)
+
+	for _, mapping := range mappingsToIRC(d.bridge.GetMappingsByDiscord(c.ID)) {
+		if !d.bridge.topicSynced(mapping.IRCChannel) {
+			continue
+		}
+
+		if current, _ := d.bridge.ircListener.ChannelTopic(mapping.IRCChannel); current == topic {
+			continue
+		}
+
+		if !d.bridge.ircListener.ops.Has(mapping.IRCChannel) {
+			log.WithField("channel", mapping.IRCChannel).Infoln("Discord topic changed, but can't set the IRC topic without ops")
+			continue
+		}
+
+		d.bridge.ircListener.SendRawf("TOPIC %s :%s", mapping.IRCChannel, topic)
+	}
+}
@@ -0,0 +1,55 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamesOp(t *testing.T) {
+	tests := []struct {
+		names string
+		op    bool
+		found bool
+	}{
+		{"alice @bridge bob", true, true},
+		{"alice bridge bob", false, true},
+		{"+bridge", false, true},
+		{"@+Bridge", true, true},
+		{"~bridge", true, true},
+		{"%bridge", false, true},
+		{"alice @bob", false, false},
+		{"@bridgebot", false, false},
+	}
+
+	for _, tt := range tests {
+		op, found := namesOp("bridge", tt.names)
+		assert.Equal(t, tt.op, op, tt.names)
+		assert.Equal(t, tt.found, found, tt.names)
+	}
+}
+
+func TestModeOp(t *testing.T) {
+	tests := []struct {
+		args    []string
+		op      bool
+		changed bool
+	}{
+		{[]string{"#chan", "+o", "bridge"}, true, true},
+		{[]string{"#chan", "-o", "bridge"}, false, true},
+		{[]string{"#chan", "+o", "alice"}, false, false},
+		{[]string{"#chan", "+vo", "bridge", "alice"}, false, false},
+		{[]string{"#chan", "+ov", "alice", "bridge"}, false, false},
+		{[]string{"#chan", "+lo", "10", "bridge"}, true, true},
+		{[]string{"#chan", "-lo", "bridge"}, false, true},
+		{[]string{"#chan", "+o-o", "bridge", "bridge"}, false, true},
+		{[]string{"#chan", "+nt"}, false, false},
+		{[]string{"#chan"}, false, false},
+	}
+
+	for _, tt := range tests {
+		op, changed := modeOp("bridge", tt.args)
+		assert.Equal(t, tt.op, op, tt.args)
+		assert.Equal(t, tt.changed, changed, tt.args)
+	}
+}
@@ -0,0 +1,263 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	irc "github.com/qaisjp/go-ircevent"
+	log "github.com/sirupsen/logrus"
+)
+
+// userListsKey is where the Discord messages of user lists are saved in the store
+const userListsKey = "user_lists"
+
+// userListDelay is how long to wait after someone joins or leaves before
+// updating a user list, so that a netsplit is one edit instead of hundreds
+const userListDelay = 10 * time.Second
+
+// userListPin is the user_lists value that pins the list in the bridged channel
+const userListPin = "pin"
+
+// A userListTarget is a Discord channel an IRC channel's user list is kept in
+type userListTarget struct {
+	ChannelID string
+	Pin       bool
+}
+
+// userListMessage is the Discord message showing a channel's user list
+type userListMessage struct {
+	ChannelID string `json:"channel_id"`
+	MessageID string `json:"message_id"`
+}
+
+// userLists keeps Discord messages up to date with who is in IRC channels.
+//
+// It is safe to use from multiple goroutines.
+type userLists struct {
+	bridge *Bridge
+
+	mu      sync.Mutex
+	pending map[string]bool // by lowercase IRC channel
+
+	// Only one list is sent at a time, so the messages can be saved
+	updateMu sync.Mutex
+	content  map[string]string          // last content sent, by userListKey
+	messages map[string]userListMessage // by userListKey
+}
+
+// userListKey is the key of the user list of an IRC channel in a Discord channel
+func userListKey(ircChannel, channelID string) string {
+	return strings.ToLower(ircChannel) + " " + channelID
+}
+
+func newUserLists(b *Bridge) *userLists {
+	l := &userLists{
+		bridge:   b,
+		pending:  make(map[string]bool),
+		content:  make(map[string]string),
+		messages: make(map[string]userListMessage),
+	}
+
+	if b.store != nil {
+		if _, err := b.store.Get(userListsKey, &l.messages); err != nil {
+			log.WithError(err).Errorln("could not read user list messages, new ones will be sent")
+		}
+	}
+	return l
+}
+
+// targets returns where an IRC channel's user lists go. Pinned lists go
+// in each Discord channel the IRC channel is bridged to.
+func (l *userLists) targets(ircChannel string) []userListTarget {
+	for channel, target := range l.bridge.Config.UserLists {
+		if !strings.EqualFold(channel, ircChannel) {
+			continue
+		}
+
+		if target != userListPin {
+			return []userListTarget{{ChannelID: target}}
+		}
+
+		var targets []userListTarget
+		seen := make(map[string]bool)
+		for _, mapping := range l.bridge.GetMappingsByIRC(ircChannel) {
+			if !seen[mapping.DiscordChannel] {
+				seen[mapping.DiscordChannel] = true
+				targets = append(targets, userListTarget{ChannelID: mapping.DiscordChannel, Pin: true})
+			}
+		}
+		return targets
+	}
+	return nil
+}
+
+// Changed updates the user list of an IRC channel after a short delay,
+// or all user lists if channel is blank
+func (l *userLists) Changed(channel string) {
+	var channels []string
+	if channel != "" {
+		channels = []string{channel}
+	} else {
+		for ircChannel := range l.bridge.Config.UserLists {
+			channels = append(channels, ircChannel)
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, channel := range channels {
+		if len(l.targets(channel)) == 0 {
+			continue
+		}
+
+		key := strings.ToLower(channel)
+		if l.pending[key] {
+			continue
+		}
+		l.pending[key] = true
+
+		channel := channel
+		time.AfterFunc(userListDelay, func() {
+			l.mu.Lock()
+			delete(l.pending, key)
+			l.mu.Unlock()
+
+			l.update(channel)
+		})
+	}
+}
+
+// update sends or edits the user lists of an IRC channel
+func (l *userLists) update(ircChannel string) {
+	targets := l.targets(ircChannel)
+	if len(targets) == 0 {
+		return
+	}
+
+	channel, ok := l.bridge.ircListener.GetChannel(ircChannel)
+	if !ok {
+		return
+	}
+
+	// The puppets belong to the loop, so copy their nicks
+	listenerNick := l.bridge.ircListener.GetNick()
+	puppets := make(map[string]bool)
+	l.bridge.inLoop(func() {
+		for nick := range l.bridge.ircManager.puppetNicks {
+			puppets[nick] = true
+		}
+	})
+
+	var ops, voiced, others []string
+	channel.IterUsers(func(nick string, u *irc.User) {
+		if puppets[nick] || nick == listenerNick {
+			return
+		}
+
+		switch {
+		case strings.ContainsAny(u.Mode, "qao"):
+			ops = append(ops, nick)
+		case strings.ContainsAny(u.Mode, "hv"):
+			voiced = append(voiced, nick)
+		default:
+			others = append(others, nick)
+		}
+	})
+	content := renderUserList(ircChannel, ops, voiced, others)
+
+	l.updateMu.Lock()
+	defer l.updateMu.Unlock()
+
+	for _, target := range targets {
+		l.send(ircChannel, target, content)
+	}
+}
+
+// send sends or edits the user list of an IRC channel in a Discord channel.
+// updateMu must be held.
+func (l *userLists) send(ircChannel string, target userListTarget, content string) {
+	key := userListKey(ircChannel, target.ChannelID)
+	if l.content[key] == content {
+		return
+	}
+
+	d := l.bridge.discord
+	if msg, ok := l.messages[key]; ok {
+		if _, err := d.Session.ChannelMessageEdit(target.ChannelID, msg.MessageID, content); err == nil {
+			l.content[key] = content
+			return
+		}
+		log.WithField("channel", ircChannel).Infoln("could not edit user list, sending a new one")
+	}
+
+	msg, err := d.Session.ChannelMessageSend(target.ChannelID, content)
+	if err != nil {
+		log.WithError(err).WithField("channel", ircChannel).Errorln("could not send user list")
+		return
+	}
+	l.content[key] = content
+
+	if target.Pin {
+		if err := d.Session.ChannelMessagePin(target.ChannelID, msg.ID); err != nil {
+			log.WithError(err).WithField("channel", ircChannel).Warnln("could not pin user list, does the bot have Manage Messages?")
+		}
+	}
+
+	l.messages[key] = userListMessage{ChannelID: target.ChannelID, MessageID: msg.ID}
+	if l.bridge.store != nil {
+		if err := l.bridge.store.Set(userListsKey, l.messages); err != nil {
+			log.WithError(err).Errorln("could not save user list message")
+		}
+	}
+}
+
+// renderUserList formats the users in an IRC channel, grouped by prefix
+func renderUserList(ircChannel string, ops, voiced, others []string) string {
+	var lines []string
+	for _, group := range []struct {
+		prefix string
+		nicks  []string
+	}{{"@", ops}, {"+", voiced}, {"", others}} {
+		if len(group.nicks) == 0 {
+			continue
+		}
+
+		nicks := make([]string, len(group.nicks))
+		for i, nick := range group.nicks {
+			nicks[i] = group.prefix + nick
+		}
+		sort.Slice(nicks, func(i, j int) bool {
+			return strings.ToLower(nicks[i]) < strings.ToLower(nicks[j])
+		})
+		lines = append(lines, strings.Join(nicks, " "))
+	}
+
+	count := len(ops) + len(voiced) + len(others)
+	if count == 0 {
+		return fmt.Sprintf("Nobody from IRC is in %s.", ircChannel)
+	}
+
+	return truncateMessage(fmt.Sprintf("%s in %s on IRC:\n```\n%s\n```", plural(count, "user"), ircChannel, strings.Join(lines, "\n")))
+}
+
+// onUserListEvent updates user lists when someone joins, leaves,
+// changes their nick or has their prefix changed
+func (i *ircListener) onUserListEvent(e *irc.Event) {
+	switch e.Code {
+	case "STQUIT", "STNICK":
+		i.bridge.userLists.Changed("")
+	case "366":
+		// RPL_ENDOFNAMES: <client> <channel> :End of /NAMES list
+		if len(e.Arguments) > 1 {
+			i.bridge.userLists.Changed(e.Arguments[1])
+		}
+	default:
+		if len(e.Arguments) > 0 && strings.HasPrefix(e.Arguments[0], "#") {
+			i.bridge.userLists.Changed(e.Arguments[0])
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package bridge
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderUserList(t *testing.T) {
+	tests := []struct {
+		ops, voiced, others []string
+		expected            string
+	}{
+		{
+			nil, nil, nil,
+			"Nobody from IRC is in #chan.",
+		},
+		{
+			[]string{"zed", "Alice"}, []string{"bob"}, []string{"dave", "carol"},
+			"5 users in #chan on IRC:\n```\n@Alice @zed\n+bob\ncarol dave\n```",
+		},
+		{
+			nil, nil, []string{"carol"},
+			"1 user in #chan on IRC:\n```\ncarol\n```",
+		},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, renderUserList("#chan", tt.ops, tt.voiced, tt.others))
+	}
+}
+
+func TestUserListTargets(t *testing.T) {
+	b := &Bridge{
+		Config: &Config{UserLists: map[string]string{"#a": "pin", "#B": "9"}},
+		mappings: []Mapping{
+			{IRCChannel: "#a", DiscordChannel: "1"},
+			{IRCChannel: "#a", DiscordChannel: "2", Direction: DirectionIRCToDiscord},
+			{IRCChannel: "#b", DiscordChannel: "1"},
+			{IRCChannel: "#c", DiscordChannel: "3"},
+		},
+	}
+	l := &userLists{bridge: b}
+
+	assert.Equal(t, []userListTarget{{ChannelID: "1", Pin: true}, {ChannelID: "2", Pin: true}}, l.targets("#A"))
+	assert.Equal(t, []userListTarget{{ChannelID: "9"}}, l.targets("#b"))
+	assert.Empty(t, l.targets("#c"))
+}
@@ -0,0 +1,162 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	log "github.com/sirupsen/logrus"
+)
+
+// At most voiceAnnounceBurst voice announcements are sent to
+// an IRC channel every voiceAnnounceInterval
+const (
+	voiceAnnounceBurst    = 5
+	voiceAnnounceInterval = time.Minute
+)
+
+// voiceLimiter limits how many voice announcements are sent to each IRC
+// channel, so that people reconnecting repeatedly can't flood it.
+//
+// It is safe to use from multiple goroutines.
+type voiceLimiter struct {
+	mu   sync.Mutex
+	sent map[string][]time.Time // by IRC channel, oldest first
+}
+
+func newVoiceLimiter() *voiceLimiter {
+	return &voiceLimiter{sent: make(map[string][]time.Time)}
+}
+
+// Allow returns whether an announcement can be sent to a channel now
+func (l *voiceLimiter) Allow(ircChannel string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := strings.ToLower(ircChannel)
+	sent := l.sent[key]
+	for len(sent) > 0 && now.Sub(sent[0]) >= voiceAnnounceInterval {
+		sent = sent[1:]
+	}
+
+	if len(sent) >= voiceAnnounceBurst {
+		l.sent[key] = sent
+		return false
+	}
+
+	l.sent[key] = append(sent, now)
+	return true
+}
+
+func (d *discordBot) onVoiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if !d.isGuild(v.GuildID) {
+		return
+	}
+
+	var before string
+	if v.BeforeUpdate != nil {
+		before = v.BeforeUpdate.ChannelID
+	}
+	after := v.ChannelID
+
+	// Mute, deafen and stream changes don't change the channel
+	if before == after {
+		return
+	}
+
+	nick := d.voiceNick(v.VoiceState)
+	beforeIRC := d.bridge.Config.VoiceChannels[before]
+	afterIRC := d.bridge.Config.VoiceChannels[after]
+
+	if beforeIRC != "" && strings.EqualFold(beforeIRC, afterIRC) {
+		d.announceVoice(afterIRC, fmt.Sprintf("%s moved from voice channel %s to %s", nick, d.channelName(before), d.channelName(after)))
+		return
+	}
+
+	if beforeIRC != "" {
+		d.announceVoice(beforeIRC, fmt.Sprintf("%s left voice channel %s", nick, d.channelName(before)))
+	}
+	if afterIRC != "" {
+		d.announceVoice(afterIRC, fmt.Sprintf("%s joined voice channel %s", nick, d.channelName(after)))
+	}
+}
+
+func (d *discordBot) announceVoice(ircChannel, text string) {
+	if !d.voiceLimiter.Allow(ircChannel, time.Now()) {
+		log.WithField("channel", ircChannel).Debugln("dropping voice announcement, too many recently:", text)
+		return
+	}
+
+	d.bridge.ircListener.Notice(ircChannel, text)
+}
+
+// voiceNick returns the display name of the user in a voice state
+func (d *discordBot) voiceNick(v *discordgo.VoiceState) string {
+	member := v.Member
+	if member == nil || member.User == nil {
+		var err error
+		if member, _, err = d.member(v.UserID); err != nil {
+			return v.UserID
+		}
+	}
+
+	return GetMemberNick(member)
+}
+
+func (d *discordBot) channelName(channelID string) string {
+	channel, err := d.Session.State.Channel(channelID)
+	if err != nil {
+		return channelID
+	}
+	return channel.Name
+}
+
+// voiceSummary lists who is in each voice channel mapped to an IRC channel,
+// like "General: alice, bob; Gaming: nobody"
+func (d *discordBot) voiceSummary(ircChannel string) string {
+	var channelIDs []string
+	for channelID, mapped := range d.bridge.Config.VoiceChannels {
+		if strings.EqualFold(mapped, ircChannel) {
+			channelIDs = append(channelIDs, channelID)
+		}
+	}
+	if len(channelIDs) == 0 {
+		return "no voice channels are bridged to " + ircChannel
+	}
+
+	members := make(map[string][]string)
+	for _, guildID := range d.guildIDs {
+		guild, err := d.Session.State.Guild(guildID)
+		if err != nil {
+			log.WithError(err).Errorln("could not get guild to list voice channel members")
+			return "could not list voice channel members"
+		}
+
+		d.Session.State.RLock()
+		for _, v := range guild.VoiceStates {
+			members[v.ChannelID] = append(members[v.ChannelID], v.UserID)
+		}
+		d.Session.State.RUnlock()
+	}
+
+	var parts []string
+	for _, channelID := range channelIDs {
+		var nicks []string
+		for _, userID := range members[channelID] {
+			nicks = append(nicks, d.voiceNick(&discordgo.VoiceState{UserID: userID}))
+		}
+		sort.Strings(nicks)
+
+		list := "nobody"
+		if len(nicks) > 0 {
+			list = strings.Join(nicks, ", ")
+		}
+		parts = append(parts, d.channelName(channelID)+": "+list)
+	}
+	sort.Strings(parts)
+
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,22 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoiceLimiter(t *testing.T) {
+	l := newVoiceLimiter()
+	now := time.Now()
+
+	for i := 0; i < voiceAnnounceBurst; i++ {
+		assert.True(t, l.Allow("#chan", now))
+	}
+	assert.False(t, l.Allow("#CHAN", now))
+	assert.True(t, l.Allow("#other", now))
+
+	assert.False(t, l.Allow("#chan", now.Add(voiceAnnounceInterval-time.Second)))
+	assert.True(t, l.Allow("#chan", now.Add(voiceAnnounceInterval)))
+}
@@ -43,3 +43,8 @@ func (c *memClient) Connected(uid string) (result bool, err error) {
 	err = c.varys.Connected(uid, &result)
 	return
 }
+
+func (c *memClient) HasCapability(uid string, capability string) (result bool, err error) {
+	err = c.varys.HasCapability(CapabilityParams{uid, capability}, &result)
+	return
+}
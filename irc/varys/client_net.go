@@ -57,3 +57,8 @@ func (c *netClient) Connected(uid string) (result bool, err error) {
 	err = c.client.Call("Varys.GetNick", uid, &result)
 	return
 }
+
+func (c *netClient) HasCapability(uid string, capability string) (result bool, err error) {
+	err = c.client.Call("Varys.HasCapability", CapabilityParams{uid, capability}, &result)
+	return
+}
@@ -47,6 +47,8 @@ type Client interface {
 	GetNick(uid string) (string, error)
 	// Connected returns the status of the current connection
 	Connected(uid string) (bool, error)
+	// HasCapability returns whether the server acknowledged an IRCv3 capability
+	HasCapability(uid string, capability string) (bool, error)
 }
 
 type SetupParams struct {
@@ -82,6 +84,9 @@ type ConnectParams struct {
 
 	WebIRCSuffix string
 
+	// IRCv3 capabilities to request, if the server supports them
+	RequestCaps []string
+
 	// TODO(qaisjp): does not support net/rpc!!!!
 	Callbacks map[string]func(*irc.Event)
 }
@@ -90,6 +95,7 @@ func (v *Varys) Connect(params ConnectParams, _ *struct{}) error {
 	conn := irc.IRC(params.Nick, params.Username)
 	// conn.Debug = true
 	conn.RealName = params.RealName
+	conn.RequestCaps = params.RequestCaps
 
 	// TLS things, and the server password
 	conn.Password = v.connConfig.ServerPassword
@@ -105,13 +111,6 @@ func (v *Varys) Connect(params ConnectParams, _ *struct{}) error {
 		conn.WebIRC = v.connConfig.WebIRCPassword + " " + params.WebIRCSuffix
 	}
 
-	// On kick, rejoin the channel
-	conn.AddCallback("KICK", func(e *irc.Event) {
-		if e.Arguments[1] == conn.GetNick() {
-			conn.Join(e.Arguments[0])
-		}
-	})
-
 	for eventcode, callback := range params.Callbacks {
 		conn.AddCallback(eventcode, callback)
 	}
@@ -190,3 +189,20 @@ func (v *Varys) Nick(params NickParams, _ *struct{}) error {
 	}
 	return nil
 }
+
+type CapabilityParams struct {
+	UID        string
+	Capability string
+}
+
+func (v *Varys) HasCapability(params CapabilityParams, result *bool) error {
+	if conn, ok := v.uidToConns[params.UID]; ok {
+		for _, c := range conn.AcknowledgedCaps {
+			if c == params.Capability {
+				*result = true
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -73,11 +76,11 @@ func main() {
 		return
 	}
 	discordBotToken := viper.GetString("discord_token")                                 // Discord Bot User Token
-	channelMappings := viper.GetStringMapString("channel_mappings")                     // Discord:IRC mappings in format '#discord1:#irc1,#discord2:#irc2,...'
 	ircServer := viper.GetString("irc_server")                                          // Server address to use, example `irc.freenode.net:7000`.
 	ircPassword := viper.GetString("irc_pass")                                          // Optional password for connecting to the IRC server
 	ircListenerPrejoinCommands := viper.GetStringSlice("irc_listener_prejoin_commands") // Commands for each connection to send before joining channels
 	guildID := viper.GetString("guild_id")                                              // Guild to use
+	extraGuildIDs := viper.GetStringSlice("extra_guild_ids")                            // More guilds to bridge channels from
 	webIRCPass := viper.GetString("webirc_pass")                                        // Password for WEBIRC
 	ircIgnores := viper.GetStringSlice("ignored_irc_hostmasks")                         // IRC hosts to not relay to Discord
 	rawDiscordIgnores := viper.GetStringSlice("ignored_discord_ids")                    // Ignore these Discord users on IRC
@@ -86,6 +89,11 @@ func main() {
 	rawDiscordFilter := viper.GetStringSlice("discord_message_filter") // Ignore lines containing matched text from Discord
 	connectionLimit := viper.GetInt("connection_limit")                // Limiter on how many IRC Connections we can spawn
 	//
+	channelMappings, channelSettings, err := setupChannelMappings(viper.GetStringMap("channel_mappings")) // IRC channels to Discord channels, and their settings
+	if err != nil {
+		log.Fatalln(err)
+	}
+	//
 	if !*debugMode {
 		*debugMode = viper.GetBool("debug")
 	}
@@ -120,6 +128,60 @@ func main() {
 	//
 	viper.SetDefault("show_joinquit", false)
 	showJoinQuit := viper.GetBool("show_joinquit")
+	//
+	attachmentLimits := setupAttachmentLimits(viper.GetStringMapString("attachment_limits"))
+	//
+	viper.SetDefault("attachment_mirror_listen", ":8080")
+	viper.SetDefault("attachment_mirror_max_age", int64((time.Hour * 24 * 30).Seconds()))
+	viper.SetDefault("attachment_mirror_max_size", 1024)
+	viper.SetDefault("attachment_mirror_max_file", 100)
+	attachmentMirrorDir := viper.GetString("attachment_mirror_dir")
+	attachmentMirrorListen := viper.GetString("attachment_mirror_listen")
+	attachmentMirrorURL := viper.GetString("attachment_mirror_url")
+	attachmentMirrorMaxAge := viper.GetInt64("attachment_mirror_max_age")
+	attachmentMirrorMaxSize := viper.GetInt64("attachment_mirror_max_size")
+	attachmentMirrorMaxFile := viper.GetInt64("attachment_mirror_max_file")
+	if attachmentMirrorDir != "" && attachmentMirrorURL == "" {
+		log.Fatalln("'attachment_mirror_url' config option is required when 'attachment_mirror_dir' is set")
+	}
+	//
+	viper.SetDefault("state_file", filepath.Join(configPath, "state.json"))
+	stateFile := viper.GetString("state_file")
+	adminRoles := viper.GetStringSlice("admin_roles")
+	//
+	viper.SetDefault("irc_command_prefix", "!discord")
+	ircCommandPrefix := viper.GetString("irc_command_prefix")
+	//
+	voiceChannels := viper.GetStringMapString("voice_channels")
+	//
+	userLists := viper.GetStringMapString("user_lists")
+	//
+	ircPrefixChannels := viper.GetStringSlice("show_irc_prefixes")
+	roleModes := viper.GetStringMapString("role_modes")
+	viper.SetDefault("role_modes_via", "chanserv")
+	roleModesVia := viper.GetString("role_modes_via")
+	//
+	moderationBridging := viper.GetBool("moderation_bridging")
+	viper.SetDefault("irc_quiet_mode", "+q")
+	ircQuietMode := viper.GetString("irc_quiet_mode")
+	modLogChannel := viper.GetString("mod_log_channel")
+	//
+	pmChannel := viper.GetString("pm_channel")
+	//
+	topicSync := viper.GetStringSlice("topic_sync")
+	viper.SetDefault("topic_prefix", "[IRC] ")
+	topicPrefix := viper.GetString("topic_prefix")
+	//
+	viper.SetDefault("reaction_window", 10)
+	reactionWindow := viper.GetInt64("reaction_window")
+	//
+	viper.SetDefault("show_deletions", false)
+	showDeletions := viper.GetBool("show_deletions")
+	viper.SetDefault("deletion_notice", "[message from ${USERNAME} deleted]")
+	deletionNotice := viper.GetString("deletion_notice")
+	//
+	viper.SetDefault("irc_replies", false)
+	ircReplies := viper.GetBool("irc_replies")
 	// Maximum length of user nicks aloud
 	viper.SetDefault("max_nick_length", ircnick.MAXLENGTH)
 	maxNickLength := viper.GetInt("max_nick_length")
@@ -150,6 +212,7 @@ func main() {
 		Discriminator:              discriminator,
 		DiscordBotToken:            discordBotToken,
 		GuildID:                    guildID,
+		ExtraGuildIDs:              extraGuildIDs,
 		IRCListenerName:            ircUsername,
 		IRCServer:                  ircServer,
 		IRCServerPass:              ircPassword,
@@ -171,6 +234,32 @@ func main() {
 		ChannelMappings:            channelMappings,
 		CooldownDuration:           time.Second * time.Duration(cooldownDuration),
 		ShowJoinQuit:               showJoinQuit,
+		ChannelSettings:            channelSettings,
+		AttachmentLimits:           attachmentLimits,
+		AttachmentMirrorDir:        attachmentMirrorDir,
+		AttachmentMirrorListen:     attachmentMirrorListen,
+		AttachmentMirrorURL:        attachmentMirrorURL,
+		AttachmentMirrorMaxAge:     time.Second * time.Duration(attachmentMirrorMaxAge),
+		AttachmentMirrorMaxSize:    attachmentMirrorMaxSize * 1024 * 1024,
+		AttachmentMirrorMaxFile:    attachmentMirrorMaxFile * 1024 * 1024,
+		StateFile:                  stateFile,
+		AdminRoles:                 adminRoles,
+		IRCCommandPrefix:           ircCommandPrefix,
+		VoiceChannels:              voiceChannels,
+		UserLists:                  userLists,
+		IRCPrefixChannels:          ircPrefixChannels,
+		RoleModes:                  roleModes,
+		RoleModesVia:               roleModesVia,
+		ModerationBridging:         moderationBridging,
+		IRCQuietMode:               ircQuietMode,
+		ModLogChannel:              modLogChannel,
+		PMChannel:                  pmChannel,
+		TopicSync:                  topicSync,
+		TopicPrefix:                topicPrefix,
+		ReactionWindow:             time.Second * time.Duration(reactionWindow),
+		ShowDeletions:              showDeletions,
+		DeletionNotice:             deletionNotice,
+		IRCReplies:                 ircReplies,
 		MaxNickLength:              maxNickLength,
 
 		Debug:         *debugMode,
@@ -184,6 +273,24 @@ func main() {
 
 	log.Infoln("Cooldown duration for IRC puppets is", dib.Config.CooldownDuration)
 
+	// Other IRC networks, sharing the Discord session
+	networks := make(map[string]*bridge.Bridge)
+	networkMappings := make(map[string]map[string]string)
+	for _, name := range networkNames(viper) {
+		conf, err := networkConfig(viper, dib.Config, name, configPath)
+		if err != nil {
+			log.WithField("network", name).Fatalln(err)
+		}
+		networkMappings[name] = conf.ChannelMappings
+
+		network, err := dib.AddNetwork(conf)
+		if err != nil {
+			log.WithField("error", err).WithField("network", name).Fatalln("Go-Discord-IRC failed to initialise.")
+			return
+		}
+		networks[name] = network
+	}
+
 	// Create new signal receiver
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
@@ -220,6 +327,21 @@ func main() {
 		avatarURL := viper.GetString("avatar_url")
 		dib.Config.AvatarURL = avatarURL
 
+		setupChannelSettings(viper, dib.Config, "")
+		dib.Config.AdminRoles = viper.GetStringSlice("admin_roles")
+		dib.Config.IRCCommandPrefix = viper.GetString("irc_command_prefix")
+		dib.Config.RoleModes = viper.GetStringMapString("role_modes")
+		dib.Config.RoleModesVia = viper.GetString("role_modes_via")
+		dib.Config.ModerationBridging = viper.GetBool("moderation_bridging")
+		dib.Config.IRCQuietMode = viper.GetString("irc_quiet_mode")
+		dib.Config.ModLogChannel = viper.GetString("mod_log_channel")
+		dib.Config.PMChannel = viper.GetString("pm_channel")
+		dib.Config.TopicPrefix = viper.GetString("topic_prefix")
+		dib.Config.ReactionWindow = time.Second * time.Duration(viper.GetInt64("reaction_window"))
+		dib.Config.ShowDeletions = viper.GetBool("show_deletions")
+		dib.Config.DeletionNotice = viper.GetString("deletion_notice")
+		dib.Config.IRCReplies = viper.GetBool("irc_replies")
+
 		if debug := viper.GetBool("debug"); *debugMode != debug {
 			log.Printf("Debug changed from %+v to %+v", *debugMode, debug)
 			*debugMode = debug
@@ -237,9 +359,14 @@ func main() {
 			dib.Config.DiscordAllowed = stringSliceToMap(rawDiscordAllowed)
 		}
 
-		chans := viper.GetStringMapString("channel_mappings")
+		chans, settings, err := setupChannelMappings(viper.GetStringMap("channel_mappings"))
+		if err != nil {
+			log.WithField("error", err).Errorln("Not applying changes to channel mappings")
+		} else {
+			dib.SetChannelSettings(settings)
+		}
 		equalChans := reflect.DeepEqual(chans, channelMappings)
-		if !equalChans {
+		if err == nil && !equalChans {
 			log.Println("Channel mappings updated!")
 			if len(chans) == 0 {
 				log.Println("Channel mappings are missing! Not applying changes in case this was an accident.")
@@ -251,6 +378,12 @@ func main() {
 				}
 			}
 		}
+
+		for _, name := range networkNames(viper) {
+			if network, ok := networks[name]; ok {
+				networkMappings[name] = reloadNetwork(viper, network, dib.Config, name, configPath, networkMappings[name])
+			}
+		}
 	})
 
 	// Watch for a shutdown signal
@@ -262,6 +395,246 @@ func main() {
 	dib.Close()
 }
 
+// networkNames returns the names of the other IRC networks, in order
+func networkNames(viper *viper.Viper) []string {
+	var names []string
+	for name := range viper.GetStringMap("networks") {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// networkConfig returns the config for one of the other IRC networks.
+// Settings it doesn't have are taken from the main network, except for
+// the server and settings by IRC channel.
+func networkConfig(viper *viper.Viper, main *bridge.Config, name, configPath string) (*bridge.Config, error) {
+	prefix := "networks." + name + "."
+	conf := *main
+
+	viper.SetDefault(prefix+"irc_server_name", name)
+	viper.SetDefault(prefix+"state_file", filepath.Join(configPath, "state-"+name+".json"))
+
+	conf.Discriminator = viper.GetString(prefix + "irc_server_name")
+	conf.IRCServer = viper.GetString(prefix + "irc_server")
+	conf.IRCServerPass = viper.GetString(prefix + "irc_pass")
+	conf.WebIRCPass = viper.GetString(prefix + "webirc_pass")
+	conf.NoTLS = viper.GetBool(prefix + "no_tls")
+	conf.InsecureSkipVerify = viper.GetBool(prefix + "insecure")
+	conf.StateFile = viper.GetString(prefix + "state_file")
+	var err error
+	conf.ChannelMappings, conf.ChannelSettings, err = setupChannelMappings(viper.GetStringMap(prefix + "channel_mappings"))
+	if err != nil {
+		return nil, err
+	}
+
+	if viper.IsSet(prefix + "irc_listener_name") {
+		conf.IRCListenerName = viper.GetString(prefix + "irc_listener_name")
+	}
+	if viper.IsSet(prefix + "irc_listener_prejoin_commands") {
+		conf.IRCListenerPrejoinCommands = viper.GetStringSlice(prefix + "irc_listener_prejoin_commands")
+	}
+	if viper.IsSet(prefix + "irc_puppet_prejoin_commands") {
+		conf.IRCPuppetPrejoinCommands = viper.GetStringSlice(prefix + "irc_puppet_prejoin_commands")
+	}
+	if viper.IsSet(prefix + "connection_limit") {
+		conf.ConnectionLimit = viper.GetInt(prefix + "connection_limit")
+	}
+	if viper.IsSet(prefix + "suffix") {
+		conf.Suffix = viper.GetString(prefix + "suffix")
+	}
+
+	setupChannelSettings(viper, &conf, prefix)
+	return &conf, nil
+}
+
+// setupChannelSettings reads the settings that are by IRC channel, which
+// each network has its own of. prefix is blank for the main network.
+func setupChannelSettings(viper *viper.Viper, conf *bridge.Config, prefix string) {
+	conf.AttachmentLimits = setupAttachmentLimits(viper.GetStringMapString(prefix + "attachment_limits"))
+	conf.VoiceChannels = viper.GetStringMapString(prefix + "voice_channels")
+	conf.UserLists = viper.GetStringMapString(prefix + "user_lists")
+	conf.IRCPrefixChannels = viper.GetStringSlice(prefix + "show_irc_prefixes")
+	conf.TopicSync = viper.GetStringSlice(prefix + "topic_sync")
+}
+
+// reloadNetwork applies config changes to one of the other IRC networks,
+// returning its channel mappings
+func reloadNetwork(viper *viper.Viper, network *bridge.Bridge, main *bridge.Config, name, configPath string, mappings map[string]string) map[string]string {
+	conf, err := networkConfig(viper, main, name, configPath)
+	if err != nil {
+		log.WithField("error", err).Errorf("Not applying config changes to %s", name)
+		return mappings
+	}
+
+	if conf.IRCListenerName != network.Config.IRCListenerName {
+		log.Printf("Changed irc_listener_name of %s from '%s' to '%s'", name, network.Config.IRCListenerName, conf.IRCListenerName)
+		network.SetIRCListenerName(conf.IRCListenerName)
+	}
+	if conf.Debug != network.Config.Debug {
+		network.SetDebugMode(conf.Debug)
+	}
+
+	// The settings that can change while running
+	network.Config.IRCIgnores = conf.IRCIgnores
+	network.Config.DiscordFilteredMessages = conf.DiscordFilteredMessages
+	network.Config.IRCFilteredMessages = conf.IRCFilteredMessages
+	network.Config.AvatarURL = conf.AvatarURL
+	network.Config.AttachmentLimits = conf.AttachmentLimits
+	network.Config.AdminRoles = conf.AdminRoles
+	network.Config.IRCCommandPrefix = conf.IRCCommandPrefix
+	network.Config.VoiceChannels = conf.VoiceChannels
+	network.Config.UserLists = conf.UserLists
+	network.Config.IRCPrefixChannels = conf.IRCPrefixChannels
+	network.Config.RoleModes = conf.RoleModes
+	network.Config.RoleModesVia = conf.RoleModesVia
+	network.Config.ModerationBridging = conf.ModerationBridging
+	network.Config.IRCQuietMode = conf.IRCQuietMode
+	network.Config.ModLogChannel = conf.ModLogChannel
+	network.Config.PMChannel = conf.PMChannel
+	network.Config.TopicSync = conf.TopicSync
+	network.Config.TopicPrefix = conf.TopicPrefix
+	network.Config.ReactionWindow = conf.ReactionWindow
+	network.Config.ShowDeletions = conf.ShowDeletions
+	network.Config.DeletionNotice = conf.DeletionNotice
+	network.Config.IRCReplies = conf.IRCReplies
+	network.Config.DiscordIgnores = conf.DiscordIgnores
+	network.Config.DiscordAllowed = conf.DiscordAllowed
+	network.SetChannelSettings(conf.ChannelSettings)
+
+	if reflect.DeepEqual(conf.ChannelMappings, mappings) {
+		return mappings
+	}
+
+	log.Printf("Channel mappings of %s updated!", name)
+	if len(conf.ChannelMappings) == 0 {
+		log.Printf("Channel mappings of %s are missing! Not applying changes in case this was an accident.", name)
+		return mappings
+	}
+	if err := network.SetChannelMappings(conf.ChannelMappings); err != nil {
+		log.WithField("error", err).Errorf("could not set channel mappings of %s", name)
+		return mappings
+	}
+	return conf.ChannelMappings
+}
+
+// setupChannelMappings reads channel_mappings, where IRC channels are mapped
+// either to Discord channels, or to a block of settings for their mappings.
+// It returns the mappings in the plain form, and the settings by IRC channel.
+//
+// Settings apply to all of an IRC channel's mappings, so a channel with a
+// block of settings can't have other entries, which could conflict with it.
+func setupChannelMappings(raw map[string]interface{}) (map[string]string, map[string]*bridge.MappingSettings, error) {
+	mappings := make(map[string]string, len(raw))
+	settings := make(map[string]*bridge.MappingSettings)
+	entries := make(map[string]int) // by lowercase IRC channel
+	for irc, value := range raw {
+		channel := strings.SplitN(irc, " ", 2)[0]
+		entries[strings.ToLower(channel)]++
+
+		entry, ok := value.(map[string]interface{})
+		if !ok {
+			mappings[irc] = configString(value)
+			continue
+		}
+
+		if key := configString(entry["key"]); key != "" {
+			irc = channel + " " + key
+		}
+
+		var discord []string
+		for _, id := range configStrings(entry["discord"]) {
+			if direction := configString(entry["direction"]); direction != "" {
+				id += " " + direction
+			}
+			discord = append(discord, id)
+		}
+		if len(discord) == 0 {
+			log.WithField("channel", channel).Errorln("Channel mapping has no discord channels, ignoring it")
+			continue
+		}
+
+		mappings[irc] = strings.Join(discord, ",")
+		settings[strings.ToLower(channel)] = setupMappingSettings(channel, entry)
+	}
+
+	for channel := range settings {
+		if entries[channel] > 1 {
+			return nil, nil, errors.Errorf("channel_mappings has several entries for %s, and one has settings. List all of its Discord channels in the block with settings", channel)
+		}
+	}
+
+	return mappings, settings, nil
+}
+
+// setupMappingSettings reads the settings of a channel_mappings block.
+// Settings that are left out use the global ones.
+func setupMappingSettings(channel string, entry map[string]interface{}) *bridge.MappingSettings {
+	s := &bridge.MappingSettings{
+		AvatarURL: configString(entry["avatar_url"]),
+	}
+
+	if v, ok := entry["show_joinquit"]; ok {
+		if show, ok := v.(bool); ok {
+			s.ShowJoinQuit = &show
+		} else {
+			log.WithField("channel", channel).WithField("show_joinquit", v).Errorln("Invalid show_joinquit, expected true or false")
+		}
+	}
+
+	// An empty list turns off the global one, so these are never nil if set
+	if v, ok := entry["ignored_irc_hostmasks"]; ok {
+		s.IRCIgnores = append([]glob.Glob{}, setupHostmaskMatchers(configStrings(v))...)
+	}
+	if v, ok := entry["ignored_discord_ids"]; ok {
+		s.DiscordIgnores = stringSliceToMap(configStrings(v))
+	}
+	if v, ok := entry["irc_message_filter"]; ok {
+		s.IRCFilteredMessages = append([]glob.Glob{}, setupFilter(configStrings(v))...)
+	}
+	if v, ok := entry["discord_message_filter"]; ok {
+		s.DiscordFilteredMessages = append([]glob.Glob{}, setupFilter(configStrings(v))...)
+	}
+
+	switch formatting := configString(entry["formatting"]); formatting {
+	case "", bridge.FormattingMarkdown, bridge.FormattingStrip:
+		s.Formatting = formatting
+	default:
+		log.WithField("channel", channel).WithField("formatting", formatting).Errorln("Invalid formatting, expected markdown or strip")
+	}
+
+	return s
+}
+
+// configString returns a config value as a string, or "" if it isn't set
+func configString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// configStrings returns a config value that is a list, or a comma
+// separated string, as a list of strings
+func configStrings(v interface{}) []string {
+	var list []string
+	if values, ok := v.([]interface{}); ok {
+		for _, value := range values {
+			list = append(list, configString(value))
+		}
+	} else if value := configString(v); value != "" {
+		list = strings.Split(value, ",")
+	}
+
+	var result []string
+	for _, value := range list {
+		if value = strings.TrimSpace(value); value != "" {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
 func stringSliceToMap(list []string) map[string]struct{} {
 	m := make(map[string]struct{}, len(list))
 	for _, v := range list {
@@ -300,6 +673,21 @@ func setupFilter(filters []string) []glob.Glob {
 	return matchers
 }
 
+func setupAttachmentLimits(limits map[string]string) map[string]int {
+	m := make(map[string]int, len(limits))
+	for channel, limit := range limits {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			log.WithField("channel", channel).WithField("limit", limit).Errorln("Invalid attachment limit, expected a number")
+			continue
+		}
+
+		m[channel] = n
+	}
+
+	return m
+}
+
 func SetLogDebug(debug bool) {
 	logger := log.StandardLogger()
 	if debug {
@@ -0,0 +1,320 @@
+// Package mirror downloads files into a directory and serves them over HTTP,
+// so that links to them keep working after the original URL expires.
+package mirror
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// pruneInterval is how often old files are deleted, in addition to after each download
+const pruneInterval = time.Hour
+
+// defaultMaxFileSize is the size of the largest file mirrored by default
+const defaultMaxFileSize = 100 * 1024 * 1024
+
+// keyLength is the number of hex characters used for file names
+const keyLength = 12
+
+var namePattern = regexp.MustCompile(`^[0-9a-f]{12}(\.[0-9a-z]{1,8})?$`)
+var extPattern = regexp.MustCompile(`^\.[0-9a-z]{1,8}$`)
+
+// A Mirror stores files in a directory and serves them over HTTP.
+//
+// It is safe to use from multiple goroutines.
+type Mirror struct {
+	Dir     string
+	BaseURL string // public URL the files are served from, without a trailing slash
+
+	MaxAge      time.Duration // files older than this are deleted, 0 for no limit
+	MaxSize     int64         // total size of the directory in bytes, 0 for no limit
+	MaxFileSize int64         // size of the largest file in bytes, 0 for no limit
+
+	client    *http.Client
+	mu        sync.Mutex           // held while saving or pruning files
+	downloads map[string]*download // files being downloaded, by name
+
+	done chan struct{}
+}
+
+// A download is a file being downloaded
+type download struct {
+	done chan struct{} // closed when the download has finished
+	err  error
+}
+
+// New creates a Mirror that stores files in dir, creating it if necessary
+func New(dir, baseURL string, maxAge time.Duration, maxSize int64) (*Mirror, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "could not create mirror directory")
+	}
+
+	m := &Mirror{
+		Dir:         dir,
+		BaseURL:     strings.TrimSuffix(baseURL, "/"),
+		MaxAge:      maxAge,
+		MaxSize:     maxSize,
+		MaxFileSize: defaultMaxFileSize,
+		client:      &http.Client{Timeout: time.Minute},
+		downloads:   make(map[string]*download),
+		done:        make(chan struct{}),
+	}
+
+	go m.pruneLoop()
+
+	return m, nil
+}
+
+// Close stops pruning old files
+func (m *Mirror) Close() {
+	close(m.done)
+}
+
+// Store downloads a file, returning the URL it is mirrored at.
+//
+// id must uniquely identify the file, so that storing the same file
+// again returns the same URL without downloading it again.
+func (m *Mirror) Store(id, url, filename string) (string, error) {
+	name := fileName(id, filename)
+	dest := filepath.Join(m.Dir, name)
+
+	m.mu.Lock()
+	if _, err := os.Stat(dest); err == nil {
+		m.mu.Unlock()
+		return m.BaseURL + "/" + name, nil
+	}
+
+	// Wait for the file if it's already being downloaded
+	if d, ok := m.downloads[name]; ok {
+		m.mu.Unlock()
+		<-d.done
+		if d.err != nil {
+			return "", d.err
+		}
+		return m.BaseURL + "/" + name, nil
+	}
+
+	d := &download{done: make(chan struct{})}
+	m.downloads[name] = d
+	m.mu.Unlock()
+
+	// Files are downloaded without holding the lock, so that
+	// one large file doesn't hold up the others
+	tmp, err := m.download(url)
+
+	m.mu.Lock()
+	if err == nil {
+		if err = os.Rename(tmp, dest); err != nil {
+			os.Remove(tmp)
+			err = errors.Wrap(err, "could not save file")
+		} else {
+			m.prune()
+		}
+	}
+	delete(m.downloads, name)
+	m.mu.Unlock()
+
+	d.err = err
+	close(d.done)
+
+	if err != nil {
+		return "", err
+	}
+	return m.BaseURL + "/" + name, nil
+}
+
+// fileLimit returns the size of the largest file that can be mirrored,
+// or 0 for no limit
+func (m *Mirror) fileLimit() int64 {
+	if m.MaxSize > 0 && (m.MaxFileSize <= 0 || m.MaxSize < m.MaxFileSize) {
+		return m.MaxSize
+	}
+	return m.MaxFileSize
+}
+
+// download downloads a file into a temporary file in the mirror's
+// directory, returning its path
+func (m *Mirror) download(url string) (string, error) {
+	resp, err := m.client.Get(url)
+	if err != nil {
+		return "", errors.Wrap(err, "could not download file")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not download file: %s", resp.Status)
+	}
+
+	limit := m.fileLimit()
+	if limit > 0 && resp.ContentLength > limit {
+		return "", fmt.Errorf("file is too large to mirror (%d bytes)", resp.ContentLength)
+	}
+
+	tmp, err := ioutil.TempFile(m.Dir, ".download-")
+	if err != nil {
+		return "", errors.Wrap(err, "could not create file")
+	}
+
+	body := io.Reader(resp.Body)
+	if limit > 0 {
+		body = io.LimitReader(resp.Body, limit+1)
+	}
+
+	written, err := io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", errors.Wrap(err, "could not write file")
+	}
+	if limit > 0 && written > limit {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("file is too large to mirror (more than %d bytes)", limit)
+	}
+
+	return tmp.Name(), nil
+}
+
+// fileName returns a short, stable file name for a file ID,
+// keeping the extension of the original file name
+func fileName(id, filename string) string {
+	sum := sha256.Sum256([]byte(id))
+	name := hex.EncodeToString(sum[:])[:keyLength]
+
+	ext := strings.ToLower(path.Ext(filename))
+	if extPattern.MatchString(ext) {
+		name += ext
+	}
+	return name
+}
+
+func (m *Mirror) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		m.mu.Lock()
+		m.prune()
+		m.mu.Unlock()
+
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// prune deletes files older than MaxAge, and then the oldest files
+// until the directory is no larger than MaxSize. m.mu must be held.
+func (m *Mirror) prune() {
+	files, err := ioutil.ReadDir(m.Dir)
+	if err != nil {
+		log.WithError(err).Errorln("could not list mirrored files")
+		return
+	}
+
+	var kept []os.FileInfo
+	var size int64
+	for _, f := range files {
+		if !namePattern.MatchString(f.Name()) {
+			continue
+		}
+
+		if m.MaxAge > 0 && time.Since(f.ModTime()) > m.MaxAge {
+			m.remove(f.Name())
+			continue
+		}
+
+		kept = append(kept, f)
+		size += f.Size()
+	}
+
+	if m.MaxSize <= 0 {
+		return
+	}
+
+	sort.Slice(kept, func(i, j int) bool {
+		return kept[i].ModTime().Before(kept[j].ModTime())
+	})
+	for len(kept) > 0 && size > m.MaxSize {
+		m.remove(kept[0].Name())
+		size -= kept[0].Size()
+		kept = kept[1:]
+	}
+}
+
+func (m *Mirror) remove(name string) {
+	if err := os.Remove(filepath.Join(m.Dir, name)); err != nil && !os.IsNotExist(err) {
+		log.WithError(err).WithField("file", name).Errorln("could not delete mirrored file")
+	}
+}
+
+// ServeHTTP serves mirrored files by name. Nothing else is served,
+// including directory listings.
+func (m *Mirror) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if !namePattern.MatchString(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(m.Dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Only media is shown in the browser, so that a mirrored page can't
+	// run scripts on the mirror's domain
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !inline(name) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// inline returns whether a file is safe to display in the browser
+func inline(name string) bool {
+	t := mime.TypeByExtension(path.Ext(name))
+	if t == "" || strings.HasPrefix(t, "image/svg") {
+		return false
+	}
+
+	for _, prefix := range []string{"image/", "video/", "audio/", "text/plain"} {
+		if strings.HasPrefix(t, prefix) {
+			return true
+		}
+	}
+	return false
+}
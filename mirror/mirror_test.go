@@ -0,0 +1,180 @@
+package mirror
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMirror(t *testing.T) {
+	downloads := 0
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		w.Write([]byte("contents of " + r.URL.Path))
+	}))
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := New(dir, "https://files.example.com/", 0, 0)
+	require.NoError(t, err)
+	defer m.Close()
+
+	url, err := m.Store("1", origin.URL+"/cat.png", "Cat.PNG")
+	require.NoError(t, err)
+	assert.Regexp(t, `^https://files\.example\.com/[0-9a-f]{12}\.png$`, url)
+
+	// The same file isn't downloaded twice
+	again, err := m.Store("1", origin.URL+"/cat.png", "Cat.PNG")
+	require.NoError(t, err)
+	assert.Equal(t, url, again)
+	assert.Equal(t, 1, downloads)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", strings.TrimPrefix(url, "https://files.example.com"), nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "contents of /cat.png", rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+
+	for _, path := range []string{"/", "/../mirror", "/.download-1", "/0123456789ab.png"} {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		assert.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+
+	// Pages are downloaded rather than shown
+	url, err = m.Store("2", origin.URL+"/page.html", "page.html")
+	require.NoError(t, err)
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", strings.TrimPrefix(url, "https://files.example.com"), nil))
+	assert.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+}
+
+func TestMirrorPrune(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := New(dir, "", time.Hour, 25)
+	require.NoError(t, err)
+	defer m.Close()
+
+	var names []string
+	for _, id := range []string{"1", "2", "3"} {
+		_, err := m.Store(id, origin.URL, "")
+		require.NoError(t, err)
+		names = append(names, fileName(id, ""))
+
+		// Make sure each file is newer than the last
+		past := time.Now().Add(-time.Duration(10-len(names)) * time.Minute)
+		require.NoError(t, os.Chtimes(filepath.Join(dir, names[len(names)-1]), past, past))
+	}
+
+	// The oldest file is deleted to stay under the size limit
+	_, err = os.Stat(filepath.Join(dir, names[0]))
+	assert.True(t, os.IsNotExist(err))
+
+	// Old files are deleted
+	past := time.Now().Add(-2 * time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(dir, names[1]), past, past))
+	m.mu.Lock()
+	m.prune()
+	m.mu.Unlock()
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, names[2], files[0].Name())
+	}
+
+	// Files larger than the mirror aren't stored
+	big := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 30)))
+	}))
+	defer big.Close()
+	_, err = m.Store("4", big.URL, "")
+	assert.Error(t, err)
+}
+
+func TestMirrorFileLimit(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 10)))
+	}))
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := New(dir, "", 0, 0)
+	require.NoError(t, err)
+	defer m.Close()
+	m.MaxFileSize = 5
+
+	_, err = m.Store("1", origin.URL, "")
+	assert.Error(t, err)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestMirrorConcurrentStores(t *testing.T) {
+	var mu sync.Mutex
+	downloads := 0
+	slow := make(chan struct{})
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		downloads++
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-slow
+		}
+		w.Write([]byte("contents"))
+	}))
+	defer origin.Close()
+
+	dir, err := ioutil.TempDir("", "mirror")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := New(dir, "", 0, 0)
+	require.NoError(t, err)
+	defer m.Close()
+
+	// The same file stored twice at once is only downloaded once
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Store("slow", origin.URL+"/slow", "")
+			assert.NoError(t, err)
+		}()
+	}
+
+	// A slow download doesn't hold up other files
+	_, err = m.Store("fast", origin.URL+"/fast", "")
+	assert.NoError(t, err)
+
+	close(slow)
+	wg.Wait()
+
+	assert.Equal(t, 2, downloads)
+}
@@ -0,0 +1,104 @@
+// Package store persists small amounts of state, like settings changed
+// at runtime, to a JSON file.
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// A Store is a JSON file of keys and values.
+//
+// It is safe to use from multiple goroutines.
+type Store struct {
+	path string
+
+	mu   sync.Mutex
+	data map[string]json.RawMessage
+}
+
+// Open reads the store at path, which is created when first written to
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path: path,
+		data: make(map[string]json.RawMessage),
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "could not read store")
+	}
+
+	if err := json.Unmarshal(contents, &s.data); err != nil {
+		return nil, errors.Wrapf(err, "could not parse store %s", path)
+	}
+	return s, nil
+}
+
+// Get decodes the value of key into v, returning false if it isn't set
+func (s *Store) Get(key string, v interface{}) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, ok := s.data[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// Set changes the value of key, and saves the store
+func (s *Store) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrapf(err, "could not encode %s", key)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, existed := s.data[key]
+	s.data[key] = raw
+	if err := s.save(); err != nil {
+		// Keep what's in memory the same as what's on disk
+		if existed {
+			s.data[key] = previous
+		} else {
+			delete(s.data, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes the store to a temporary file, and then renames it over the
+// store, so that the store isn't left half written. s.mu must be held.
+func (s *Store) save() error {
+	contents, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "could not encode store")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), "."+filepath.Base(s.path)+"-")
+	if err != nil {
+		return errors.Wrap(err, "could not save store")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(contents)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "could not save store")
+	}
+
+	return errors.Wrap(os.Rename(tmp.Name(), s.path), "could not save store")
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	s, err := Open(path)
+	require.NoError(t, err)
+
+	var nicks map[string]string
+	ok, err := s.Get("nicks", &nicks)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, s.Set("nicks", map[string]string{"123": "alice"}))
+	require.NoError(t, s.Set("count", 2))
+
+	// Reopening reads what was saved
+	s, err = Open(path)
+	require.NoError(t, err)
+
+	ok, err = s.Get("nicks", &nicks)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]string{"123": "alice"}, nicks)
+
+	var count int
+	_, err = s.Get("count", &count)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
+
+func TestStoreInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0644))
+
+	_, err = Open(path)
+	assert.Error(t, err)
+}